@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"path/filepath"
 	"strings"
+	textTemplate "text/template"
 )
 
 type Template struct {
@@ -16,6 +18,10 @@ type Template struct {
 
 var styles map[string]template.CSS
 
+// Hash of the styles, so that cached output that uses them can be
+// invalidated when they change.
+var stylesVersion string
+
 func loadTemplates() (templates map[string]*Template) {
 	styles = loadStyles()
 	funcMap := template.FuncMap{
@@ -54,11 +60,46 @@ func loadTemplates() (templates map[string]*Template) {
 	return templates
 }
 
+// loadTextTemplates loads the plain text versions of emails (the .txt files
+// next to the HTML templates).
+func loadTextTemplates() (templates map[string]*textTemplate.Template) {
+	funcMap := textTemplate.FuncMap{
+		"indent": func(prefix string, text string) string {
+			lines := strings.Split(strings.Trim(text, "\n"), "\n")
+			return prefix + strings.Join(lines, "\n"+prefix)
+		},
+	}
+	sharedFileNames, err := filepath.Glob("templates/shared/*.txt")
+	if err != nil {
+		log.Panicf("Could not read shared text template file names %s", err.Error())
+	}
+	templateFileNames, err := filepath.Glob("templates/*.txt")
+	if err != nil {
+		log.Panicf("Could not read text template file names %s", err.Error())
+	}
+	templates = make(map[string]*textTemplate.Template)
+	for _, templateFileName := range templateFileNames {
+		templateName := filepath.Base(templateFileName)
+		templateName = strings.TrimSuffix(templateName, filepath.Ext(templateName))
+		fileNames := make([]string, 0, len(sharedFileNames)+2)
+		fileNames = append(fileNames, templateFileName)
+		fileNames = append(fileNames, sharedFileNames...)
+		_, templateFileName = filepath.Split(fileNames[0])
+		parsedTemplate, err := textTemplate.New(templateFileName).Funcs(funcMap).ParseFiles(fileNames...)
+		if err != nil {
+			log.Printf("Could not parse text template files for %s: %s", templateFileName, err.Error())
+		}
+		templates[templateName] = parsedTemplate
+	}
+	return templates
+}
+
 func loadStyles() (result map[string]template.CSS) {
 	stylesBytes, err := ioutil.ReadFile("config/styles.json")
 	if err != nil {
 		log.Panicf("Could not read styles JSON: %s", err.Error())
 	}
+	stylesVersion = fmt.Sprintf("%x", sha256.Sum256(stylesBytes))
 	var stylesJson interface{}
 	err = json.Unmarshal(stylesBytes, &stylesJson)
 	result = make(map[string]template.CSS)
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	log_ "log"
 	"net/http"
 	"strings"
+	textTemplate "text/template"
 	"time"
 
 	"github.com/mailgun/mailgun-go"
@@ -22,6 +22,7 @@ import (
 )
 
 var templates map[string]*Template
+var textTemplates map[string]*textTemplate.Template
 
 type MailgunConfig struct {
 	Domain    string
@@ -32,21 +33,92 @@ type MailgunConfig struct {
 
 var config MailgunConfig
 
+type HookConfig struct {
+	// Secrets used to sign webhook deliveries, keyed by repository full name
+	// (e.g. "mihaip/better-github-mail").
+	WebhookSecrets map[string]string
+	// Secret accepted for deliveries about any repository, and for those
+	// that aren't about a repository (e.g. from organization hooks).
+	// Optional.
+	DefaultWebhookSecret string
+	// How long deliveries (their IDs, for the purposes of ignoring
+	// redeliveries, and their archived payloads and headers) are kept.
+	// Defaults to 30 days.
+	DeliveryRetentionDays int
+	// How many times a delivery is attempted before it is moved to the
+	// dead-letter list. Defaults to 5.
+	MaxDeliveryAttempts int
+	// Whether to send an email when a hook is first set up (and GitHub sends
+	// a ping event).
+	SendPingEmail bool
+	// Token used for GitHub API requests (optional for public repositories).
+	GitHubToken string
+	// Which CI results (status and check_suite events) are sent as replies
+	// to commit emails: "failures" (the default) or "all". Check runs are
+	// listed in their suite's reply (when GitHubToken is set), and GitHub
+	// Actions results come from workflow runs instead.
+	CheckNotificationPolicy string
+	// Workflows (by name) and branches that failed workflow runs are sent
+	// for. Entries may be path.Match patterns (e.g. "release/*"), where *
+	// doesn't match "/" ("release/*" matches "release/1.0" but not
+	// "release/1.0/hotfix"); an empty list means that all are.
+	WorkflowAllowlist       []string
+	WorkflowBranchAllowlist []string
+	// How many commits of a push are rendered in full (the rest are only
+	// counted). Defaults to 100.
+	PushCommitLimit int
+	// What to do with pushed commits that had already been pushed to another
+	// branch (e.g. when fast-forwarding): "show" them (the default), "drop"
+	// them or "collapse" them into one-line summaries (linking to the emails
+	// they were sent in). This only applies to the commits listed in the
+	// push payload, those fetched for large pushes are always shown.
+	NonDistinctCommits string
+	// Whether the commits of a pull request that a pushed merge commit
+	// merged are shown in full (by default they're collapsed into a link to
+	// the pull request's commits). Requires GitHubToken.
+	ExpandMergedPullRequestCommits bool
+	// Whether push emails include the diffs of their commits' files
+	// (requires GitHubToken). Diffs of files larger than InlineDiffFileLimit
+	// bytes (20K by default), or past InlineDiffEmailLimit bytes of rendered
+	// HTML per email (60K by default), are left out.
+	InlineDiffs          bool
+	InlineDiffFileLimit  int
+	InlineDiffEmailLimit int
+	// How Markdown in messages is rendered: "local" (the default) or "api",
+	// to use GitHub's Markdown API (falling back to local rendering if it
+	// fails).
+	MarkdownRenderer string
+}
+
+var hookConfig HookConfig
+
 func main() {
 	initConfig()
 	templates = loadTemplates()
+	textTemplates = loadTextTemplates()
 
 	http.HandleFunc("/hook", hookHandler)
 	http.HandleFunc("/hook-test-harness", hookTestHarnessHandler)
 	http.HandleFunc("/test-mail-send", testMailSendHandler)
 	http.HandleFunc("/_ah/bounce", bounceHandler)
 	http.HandleFunc("/test-email-thread", testEmailThreadHandler)
+	http.HandleFunc("/cron/expire-deliveries", expireDeliveriesHandler)
+	http.HandleFunc("/cron/expire-markdown-cache", expireMarkdownCacheHandler)
+	http.HandleFunc("/tasks/process-delivery", processDeliveryHandler)
+	http.HandleFunc("/admin/dead-letters", deadLettersHandler)
+	http.HandleFunc("/admin/deliveries", deliveriesHandler)
+	http.HandleFunc("/admin/replay", replayHandler)
 
 	appengine.Main()
 }
 
 func initConfig() {
-	path := "config/mailgun"
+	loadConfig("mailgun", &config)
+	loadConfig("hook", &hookConfig)
+}
+
+func loadConfig(name string, result interface{}) {
+	path := "config/" + name
 	if appengine.IsDevAppServer() {
 		path = path + "-dev"
 	}
@@ -55,28 +127,33 @@ func initConfig() {
 	if err != nil {
 		log_.Panicf("Could not read config from %s: %s", path, err.Error())
 	}
-	err = json.Unmarshal(configBytes, &config)
+	err = json.Unmarshal(configBytes, result)
 	if err != nil {
 		log_.Panicf("Could not parse config %s: %s", configBytes, err.Error())
 	}
 }
 
+// EmailThread records the first email sent about something (usually a commit)
+// so that later emails can be replies to it. Threads are keyed by commit SHA,
+// or by other keys for things that aren't commits.
 type EmailThread struct {
+	// The thread key (kept under its original name so that existing entities
+	// can still be loaded).
 	CommitSHA string `datastore:",noindex"`
 	Subject   string `datastore:",noindex"`
 	MessageID string `datastore:",noindex"`
 }
 
-func createThread(sha string, subject string, messageId string, c context.Context) {
-	key := datastore.NewKey(c, "EmailThread", sha, 0, nil)
+func createThread(threadKey string, subject string, messageId string, c context.Context) {
+	key := datastore.NewKey(c, "EmailThread", threadKey, 0, nil)
 	thread := new(EmailThread)
 	err := datastore.Get(c, key, thread)
 	if err == nil {
-		log.Infof(c, "Thread %s already exists for SHA = %s. Skipping.", thread.MessageID, sha)
+		log.Infof(c, "Thread %s already exists for key = %s. Skipping.", thread.MessageID, threadKey)
 		return
 	}
 
-	thread.CommitSHA = sha
+	thread.CommitSHA = threadKey
 	thread.Subject = subject
 	thread.MessageID = messageId
 	_, err = datastore.Put(c, key, thread)
@@ -87,12 +164,12 @@ func createThread(sha string, subject string, messageId string, c context.Contex
 	}
 }
 
-func getEmailThreadForCommit(sha string, c context.Context) *EmailThread {
+func getEmailThread(threadKey string, c context.Context) *EmailThread {
 	thread := new(EmailThread)
-	key := datastore.NewKey(c, "EmailThread", sha, 0, nil)
+	key := datastore.NewKey(c, "EmailThread", threadKey, 0, nil)
 	err := datastore.Get(c, key, thread)
 	if err != nil {
-		log.Infof(c, "No thread found for SHA = %s", sha)
+		log.Infof(c, "No thread found for key = %s", threadKey)
 		return nil
 	}
 	return thread
@@ -101,30 +178,76 @@ func getEmailThreadForCommit(sha string, c context.Context) *EmailThread {
 func hookHandler(w http.ResponseWriter, r *http.Request) {
 	c := appengine.NewContext(r)
 	eventType := r.Header.Get("X-Github-Event")
-	email, commits, err := handlePayload(eventType, r.Body, c)
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Errorf(c, "Error %s handling %s payload", err, eventType)
-		http.Error(w, "Error handling payload", http.StatusInternalServerError)
+		log.Errorf(c, "Could not read %s payload: %s", eventType, err)
+		http.Error(w, "Could not read payload", http.StatusBadRequest)
 		return
 	}
-	if email == nil {
-		fmt.Fprint(w, "Unhandled event type: %s", eventType)
-		log.Warningf(c, "Unhandled event type: %s", eventType)
+	if err := verifyHookRequest(r, body); err != nil {
+		log.Warningf(c, "Rejected %s delivery %s: %s",
+			eventType, r.Header.Get("X-GitHub-Delivery"), err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
 	}
-	msg, id, err := sendEmail(email, c)
-	if commits != nil {
-		for _, commit := range commits {
-			createThread(commit.SHA, email.Subject, id, c)
-		}
+	deliveryId := r.Header.Get("X-GitHub-Delivery")
+	if len(deliveryId) == 0 {
+		log.Warningf(c, "Rejected %s delivery without an ID", eventType)
+		http.Error(w, "Missing X-GitHub-Delivery", http.StatusBadRequest)
+		return
 	}
+	queued, err := enqueueHookDelivery(deliveryId, eventType, body, r.Header, c)
 	if err != nil {
-		log.Errorf(c, "Could not send mail: %s %s", err, msg)
-		http.Error(w, "Could not send mail", http.StatusInternalServerError)
+		log.Errorf(c, "Could not enqueue %s delivery %s: %s", eventType, deliveryId, err)
+		http.Error(w, "Could not enqueue delivery", http.StatusInternalServerError)
+		return
+	}
+	if !queued {
+		log.Infof(c, "Ignoring already handled %s delivery %s", eventType, deliveryId)
+		fmt.Fprint(w, "Already handled")
 		return
 	}
-	log.Infof(c, "Sent message id=%s", id)
-	fmt.Fprint(w, "OK")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, "Queued")
+}
+
+// deliverPayload generates the emails for an event and sends them. Errors are
+// returned so that the delivery can be retried. sentMessageIds are the IDs of
+// the emails that were sent by previous attempts (emails are generated in a
+// stable order, so the first len(sentMessageIds) are skipped); the IDs of all
+// the emails that have been sent so far are returned, including on failure.
+func deliverPayload(eventType string, payload []byte, sentMessageIds []string, c context.Context) ([]string, error) {
+	result, err := handlePayload(eventType, bytes.NewReader(payload), c)
+	if err != nil {
+		return sentMessageIds, fmt.Errorf("could not handle %s payload: %s", eventType, err)
+	}
+	if result == nil {
+		log.Warningf(c, "Unhandled event type: %s", eventType)
+		return sentMessageIds, nil
+	}
+	for i, email := range result.Emails {
+		if i < len(sentMessageIds) {
+			log.Infof(c, "Skipping already sent message id=%s", sentMessageIds[i])
+			continue
+		}
+		msg, id, err := sendDeliveryEmail(email, c)
+		if err != nil {
+			return sentMessageIds, fmt.Errorf("could not send mail: %s %s", err, msg)
+		}
+		log.Infof(c, "Sent message id=%s", id)
+		sentMessageIds = append(sentMessageIds, id)
+		// Threads start with the first email, so that later emails (and
+		// retries) don't create them again.
+		if i == 0 {
+			for _, key := range result.NewThreadKeys {
+				createThread(key, email.Subject, id, c)
+			}
+		}
+	}
+	if result.OnDeliver != nil {
+		return sentMessageIds, result.OnDeliver(c)
+	}
+	return sentMessageIds, nil
 }
 
 type Email struct {
@@ -132,9 +255,44 @@ type Email struct {
 	SenderUserName string
 	Subject        string
 	HTMLBody       string
-	Headers        map[string]string
+	// Plain text alternative to HTMLBody.
+	TextBody string
+	Headers  map[string]string
+}
+
+// emailUserName returns the login in a form that can be used as the local part
+// of the sender's address (as SenderUserName). Logins are alphanumeric (with
+// hyphens), except for apps' bot users, e.g. "github-actions[bot]", which
+// becomes "github-actions-bot".
+func emailUserName(login string) string {
+	userName := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_' {
+			return r
+		}
+		return '-'
+	}, login)
+	return strings.Trim(userName, "-.")
+}
+
+// renderEmailBodies renders the HTML and plain text versions of an email,
+// using the templates with the given name (e.g. "push" for push.html and
+// push.txt).
+func renderEmailBodies(templateName string, data interface{}) (htmlBody string, textBody string, err error) {
+	var mailHtml bytes.Buffer
+	if err := templates[templateName].Execute(&mailHtml, data); err != nil {
+		return "", "", err
+	}
+	var mailText bytes.Buffer
+	if err := textTemplates[templateName].Execute(&mailText, data); err != nil {
+		return "", "", err
+	}
+	return mailHtml.String(), mailText.String(), nil
 }
 
+// sendDeliveryEmail sends the emails for deliveries. A variable so that tests
+// can capture them instead.
+var sendDeliveryEmail = sendEmail
+
 func sendEmail(email *Email, c context.Context) (msg string, id string, err error) {
 	httpc := urlfetch.Client(c)
 	mg := mailgun.NewMailgun(
@@ -143,10 +301,14 @@ func sendEmail(email *Email, c context.Context) (msg string, id string, err erro
 	)
 	mg.SetClient(httpc)
 	sender := fmt.Sprintf("%s <%s@%s>", email.SenderName, email.SenderUserName, config.Domain)
+	textBody := email.TextBody
+	if len(textBody) == 0 {
+		textBody = email.HTMLBody
+	}
 	message := mg.NewMessage(
 		sender,
 		email.Subject,
-		email.HTMLBody,
+		textBody,
 		config.Recipient,
 	)
 	message.SetHtml(email.HTMLBody)
@@ -165,146 +327,12 @@ func sendEmail(email *Email, c context.Context) (msg string, id string, err erro
 	return msg, id, err
 }
 
-func handlePayload(eventType string, payloadReader io.Reader, c context.Context) (*Email, []DisplayCommit, error) {
-	decoder := json.NewDecoder(payloadReader)
-	if eventType == "push" {
-		var payload PushPayload
-		err := decoder.Decode(&payload)
-		if err != nil {
-			return nil, nil, err
-		}
-		return handlePushPayload(payload, c)
-	} else if eventType == "commit_comment" {
-		var payload CommitCommentPayload
-		err := decoder.Decode(&payload)
-		if err != nil {
-			return nil, nil, err
-		}
-		email, err := handleCommitCommentPayload(payload, c)
-		return email, nil, err
-	}
-	return nil, nil, nil
-}
-
-func handlePushPayload(payload PushPayload, c context.Context) (*Email, []DisplayCommit, error) {
-	// TODO: allow location to be customized
-	location, _ := time.LoadLocation("America/Los_Angeles")
-
-	displayCommits := make([]DisplayCommit, 0)
-	for i := range payload.Commits {
-		displayCommits = append(displayCommits, newDisplayCommit(&payload.Commits[i], payload.Sender, payload.Repo, location, c))
-	}
-	branchName := (*payload.Ref)[11:]
-	branchUrl := fmt.Sprintf("https://github.com/%s/tree/%s", *payload.Repo.FullName, branchName)
-	pushedDate := payload.Repo.PushedAt.In(location)
-	// Last link is a link so that the GitHub Gmail extension
-	// (https://github.com/muan/github-gmail) will open the diff view.
-	extensionUrl := displayCommits[0].URL
-	if len(displayCommits) > 1 {
-		extensionUrl = *payload.Compare
-	}
-	var data = map[string]interface{}{
-		"Payload":                  payload,
-		"Commits":                  displayCommits,
-		"BranchName":               branchName,
-		"BranchURL":                branchUrl,
-		"PushedDisplayDate":        safeFormattedDate(pushedDate.Format(DisplayDateFormat)),
-		"PushedDisplayDateTooltip": pushedDate.Format(DisplayDateFullFormat),
-		"ExtensionURL":             extensionUrl,
-	}
-	var mailHtml bytes.Buffer
-	if err := templates["push"].Execute(&mailHtml, data); err != nil {
-		return nil, nil, err
-	}
-
-	senderUserName := *payload.Pusher.Name
-	senderName := senderUserName
-	// We don't have the display name in the pusher, but usually it's one of the
-	// commiters, so get it from there (without having to do any extra API
-	// requests)
-	for _, commit := range payload.Commits {
-		if *commit.Author.Username == senderUserName {
-			senderName = *commit.Author.Name
-			break
-		}
-		if *commit.Committer.Username == senderUserName {
-			senderName = *commit.Committer.Name
-			break
-		}
-	}
-
-	subjectCommit := displayCommits[0]
-	subject := fmt.Sprintf("[%s] %s: %s", *payload.Repo.FullName, subjectCommit.ShortSHA, subjectCommit.Title)
-
-	message := &Email{
-		SenderName:     senderName,
-		SenderUserName: senderUserName,
-		Subject:        subject,
-		HTMLBody:       mailHtml.String(),
-	}
-	return message, displayCommits, nil
-}
-
-func handleCommitCommentPayload(payload CommitCommentPayload, c context.Context) (*Email, error) {
-	// TODO: allow location to be customized
-	location, _ := time.LoadLocation("America/Los_Angeles")
-	updatedDate := payload.Comment.UpdatedAt.In(location)
-
-	commitSHA := *payload.Comment.CommitID
-	commitShortSHA := commitSHA[:7]
-	commitURL := *payload.Repo.HTMLURL + "/commit/" + commitSHA
-
-	body := *payload.Comment.Body
-	if len(body) > 0 {
-		body = renderMessageMarkdown(body, payload.Repo, c)
-	}
-
-	var data = map[string]interface{}{
-		"Payload":            payload,
-		"Comment":            payload.Comment,
-		"Sender":             payload.Sender,
-		"Repo":               payload.Repo,
-		"ShortSHA":           commitShortSHA,
-		"Body":               body,
-		"CommitURL":          commitURL,
-		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
-	}
-
-	var mailHtml bytes.Buffer
-	if err := templates["commit-comment"].Execute(&mailHtml, data); err != nil {
-		return nil, err
-	}
-
-	senderUserName := *payload.Sender.Login
-	senderName := senderUserName
-
-	thread := getEmailThreadForCommit(commitSHA, c)
-	subject := fmt.Sprintf("[%s] %s", *payload.Repo.FullName, commitShortSHA)
-	messageId := ""
-	if thread != nil {
-		subject = thread.Subject
-		messageId = thread.MessageID
-	}
-	// We don't control the message ID, but hopefully subject-basic threading
-	// wil work.
-	subject = "Re: " + subject
-
-	message := &Email{
-		SenderName:     senderName,
-		SenderUserName: senderUserName,
-		Subject:        subject,
-		HTMLBody:       mailHtml.String(),
-		Headers:        make(map[string]string),
-	}
-	if len(messageId) > 0 {
-		message.Headers["In-Reply-To"] = messageId
-	}
-	return message, nil
-}
-
 func hookTestHarnessHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		templates["hook-test-harness"].Execute(w, nil)
+		var data = map[string]interface{}{
+			"EventTypes": registeredEventTypes(),
+		}
+		templates["hook-test-harness"].Execute(w, data)
 		return
 	}
 	if r.Method == "POST" {
@@ -312,12 +340,16 @@ func hookTestHarnessHandler(w http.ResponseWriter, r *http.Request) {
 		payload := r.FormValue("payload")
 		c := appengine.NewContext(r)
 
-		message, _, err := handlePayload(eventType, strings.NewReader(payload), c)
+		result, err := handlePayload(eventType, strings.NewReader(payload), c)
 		var data = map[string]interface{}{
+			"EventTypes": registeredEventTypes(),
 			"EventType":  eventType,
 			"Payload":    payload,
-			"Message":    message,
+			"Result":     result,
 			"MessageErr": err,
+			// Cumulative for this instance, so submitting the same payload
+			// twice shows whether the second time was served from the cache.
+			"MarkdownCacheStats": markdownCacheStats(),
 		}
 		templates["hook-test-harness"].Execute(w, data)
 		return
@@ -346,9 +378,10 @@ func testEmailThreadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	c := appengine.NewContext(r)
-	thread := getEmailThreadForCommit(sha[0], c)
+	thread := getEmailThread(sha[0], c)
 	if thread == nil {
 		http.Error(w, "No thread found", http.StatusInternalServerError)
+		return
 	}
 	fmt.Fprintf(w, "Subject: %s\n", thread.Subject)
 	fmt.Fprintf(w, "MessageID: %s\n", thread.MessageID)
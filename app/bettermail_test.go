@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+)
+
+// testInstance is shared by all tests (starting a development server for each
+// one is slow), so tests that store entities use keys that other tests don't.
+var testInstance aetest.Instance
+var testContext context.Context
+
+func TestMain(m *testing.M) {
+	templates = loadTemplates()
+	textTemplates = loadTextTemplates()
+	instance, err := aetest.NewInstance(&aetest.Options{
+		// Tests query for entities right after storing them.
+		StronglyConsistentDatastore: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	testInstance = instance
+	r := newTestRequest("GET", "/", nil)
+	testContext = appengine.NewContext(r)
+	deliveryQueueName = "default"
+	code := m.Run()
+	instance.Close()
+	os.Exit(code)
+}
+
+// newTestRequest returns a request that handlers can get an App Engine
+// context from.
+func newTestRequest(method string, url string, body io.Reader) *http.Request {
+	r, err := testInstance.NewRequest(method, url, body)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// withHookConfig replaces the hook configuration for the duration of a test,
+// returning a function that restores it.
+func withHookConfig(testConfig HookConfig) func() {
+	savedConfig := hookConfig
+	hookConfig = testConfig
+	return func() { hookConfig = savedConfig }
+}
+
+// serveGitHubAPI points GitHub API requests at a local server that responds
+// to the paths in routes with the contents of those files (in testdata/api),
+// and with a 404 to anything else. The returned function restores the real
+// API.
+func serveGitHubAPI(routes map[string]string) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileName, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := ioutil.ReadFile(filepath.Join("testdata", "api", fileName))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	savedURL := gitHubAPIURL
+	gitHubAPIURL = server.URL
+	return func() {
+		gitHubAPIURL = savedURL
+		server.Close()
+	}
+}
+
+// openTestPayload opens testdata/<fileName>, for handlers that decode the
+// payload themselves.
+func openTestPayload(t *testing.T, fileName string) *os.File {
+	file, err := os.Open(filepath.Join("testdata", fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// loadTestPayload decodes the payload in testdata/<fileName>.
+func loadTestPayload(t *testing.T, fileName string, payload interface{}) {
+	file := openTestPayload(t, fileName)
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(payload); err != nil {
+		t.Fatalf("%s: %s", fileName, err)
+	}
+}
+
+// captureEmails records the emails that deliveries send (instead of sending
+// them), returning a function that restores sending.
+func captureEmails(emails *[]*Email) func() {
+	savedSend := sendDeliveryEmail
+	sendDeliveryEmail = func(email *Email, c context.Context) (string, string, error) {
+		*emails = append(*emails, email)
+		return "Queued", fmt.Sprintf("<captured-%d@example.com>", len(*emails)), nil
+	}
+	return func() { sendDeliveryEmail = savedSend }
+}
+
+func TestEmailUserName(t *testing.T) {
+	tests := map[string]string{
+		"alice":               "alice",
+		"alice-b":             "alice-b",
+		"github-actions[bot]": "github-actions-bot",
+		"dependabot[bot]":     "dependabot-bot",
+	}
+	for login, want := range tests {
+		if got := emailUserName(login); got != want {
+			t.Errorf("%q: got %q, want %q", login, got, want)
+		}
+	}
+}
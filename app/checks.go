@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-github/github"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+// CI results (from commit statuses and check suites) are sent as replies to
+// the email for the commit that they're for. Check runs are reported as part
+// of their suite (so that a failure results in only one reply), and suites
+// from GitHub Actions are left to the workflow_run handler.
+
+type statusEventHandler struct{}
+type checkRunEventHandler struct{}
+type checkSuiteEventHandler struct{}
+
+func init() {
+	registerEventHandler("status", statusEventHandler{})
+	registerEventHandler("check_run", checkRunEventHandler{})
+	registerEventHandler("check_suite", checkSuiteEventHandler{})
+}
+
+type DisplayCheck struct {
+	Name       string
+	Conclusion string
+	// One of the pull.state.* styles.
+	ConclusionStyle string
+	Summary         string
+	SummaryHTML     string
+	URL             string
+	Date            time.Time
+	// For check suites, the runs that failed.
+	FailedRuns []DisplayCheck
+}
+
+const gitHubActionsAppSlug = "github-actions"
+
+// isFailedCheck returns whether a status state or check conclusion is a
+// failure.
+func isFailedCheck(conclusion string) bool {
+	switch conclusion {
+	case "failure", "error", "timed_out", "action_required", "startup_failure":
+		return true
+	}
+	return false
+}
+
+// checkConclusionStyle returns the pull.state.* style for a status state or
+// check conclusion.
+func checkConclusionStyle(conclusion string) string {
+	if isFailedCheck(conclusion) {
+		return "pull.state.closed"
+	} else if conclusion == "success" {
+		return "pull.state.open"
+	}
+	return "pull.state.edited"
+}
+
+func shouldNotifyForCheck(conclusion string) bool {
+	if isFailedCheck(conclusion) {
+		return true
+	}
+	return hookConfig.CheckNotificationPolicy == "all"
+}
+
+func (statusEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload StatusPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	if *payload.State == "pending" {
+		return &EventResult{}, nil
+	}
+	check := DisplayCheck{
+		Name:       *payload.Context,
+		Conclusion: *payload.State,
+	}
+	if payload.Description != nil {
+		check.Summary = *payload.Description
+		check.SummaryHTML = renderMessageMarkdown(*payload.Description, payload.Repo, c)
+	}
+	if payload.TargetURL != nil {
+		check.URL = *payload.TargetURL
+	}
+	if payload.UpdatedAt != nil {
+		check.Date = *payload.UpdatedAt
+	}
+	return handleCheck(check, *payload.SHA, payload.Repo, payload.Sender, c)
+}
+
+func (checkRunEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload CheckRunPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	if *payload.Action == "completed" {
+		log.Infof(c, "Ignoring check run %s, it's reported with its check suite", *payload.CheckRun.Name)
+	}
+	return &EventResult{}, nil
+}
+
+func newDisplayCheckRun(checkRun *WebHookCheckRun, repo *WebHookRepository, c context.Context) DisplayCheck {
+	check := DisplayCheck{
+		Name: *checkRun.Name,
+	}
+	if checkRun.Conclusion != nil {
+		check.Conclusion = *checkRun.Conclusion
+		check.ConclusionStyle = checkConclusionStyle(check.Conclusion)
+	}
+	if checkRun.Output != nil {
+		summary := ""
+		if checkRun.Output.Title != nil {
+			summary = *checkRun.Output.Title
+		}
+		if checkRun.Output.Summary != nil && len(*checkRun.Output.Summary) > 0 {
+			summary += "\n\n" + *checkRun.Output.Summary
+		}
+		if len(summary) > 0 {
+			check.Summary = summary
+			check.SummaryHTML = renderMessageMarkdown(summary, repo, c)
+		}
+	}
+	if checkRun.DetailsURL != nil && len(*checkRun.DetailsURL) > 0 {
+		check.URL = *checkRun.DetailsURL
+	} else if checkRun.HTML_URL != nil {
+		check.URL = *checkRun.HTML_URL
+	}
+	if checkRun.CompletedAt != nil {
+		check.Date = *checkRun.CompletedAt
+	}
+	return check
+}
+
+func (checkSuiteEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload CheckSuitePayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	checkSuite := payload.CheckSuite
+	if *payload.Action != "completed" || checkSuite.Conclusion == nil {
+		return &EventResult{}, nil
+	}
+	if checkSuite.App != nil && checkSuite.App.Slug != nil && *checkSuite.App.Slug == gitHubActionsAppSlug {
+		log.Infof(c, "Ignoring GitHub Actions check suite for %s, it's reported by its workflow runs", *checkSuite.HeadSHA)
+		return &EventResult{}, nil
+	}
+	name := "Check suite"
+	if checkSuite.App != nil && checkSuite.App.Name != nil {
+		name = *checkSuite.App.Name
+	}
+	check := DisplayCheck{
+		Name:       name,
+		Conclusion: *checkSuite.Conclusion,
+		// Check suites don't have their own page, link to all the checks
+		// for the commit instead.
+		URL: fmt.Sprintf("%s/commit/%s/checks", *payload.Repo.HTMLURL, *checkSuite.HeadSHA),
+	}
+	if checkSuite.UpdatedAt != nil {
+		check.Date = *checkSuite.UpdatedAt
+	}
+	// Runs aren't included in the payload, and fetching them is optional
+	// (the email links to them either way).
+	if isFailedCheck(check.Conclusion) && hasGitHubToken() && checkSuite.ID != nil &&
+		getEmailThread(*checkSuite.HeadSHA, c) != nil {
+		checkRuns, err := fetchCheckSuiteRuns(payload.Repo, *checkSuite.ID, c)
+		if err != nil {
+			log.Warningf(c, "Could not fetch runs of check suite %d: %s", *checkSuite.ID, err)
+		}
+		for i := range checkRuns {
+			checkRun := &checkRuns[i]
+			if checkRun.Conclusion != nil && isFailedCheck(*checkRun.Conclusion) {
+				check.FailedRuns = append(check.FailedRuns, newDisplayCheckRun(checkRun, payload.Repo, c))
+			}
+		}
+	}
+	return handleCheck(check, *checkSuite.HeadSHA, payload.Repo, payload.Sender, c)
+}
+
+func handleCheck(check DisplayCheck, sha string, repo *WebHookRepository, sender *github.User, c context.Context) (*EventResult, error) {
+	if !shouldNotifyForCheck(check.Conclusion) {
+		log.Infof(c, "Ignoring %s result for %s: %s", check.Name, sha, check.Conclusion)
+		return &EventResult{}, nil
+	}
+	// Only commits that we've sent an email for have somewhere to reply to.
+	if getEmailThread(sha, c) == nil {
+		log.Infof(c, "Ignoring %s result for unmailed commit %s", check.Name, sha)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	if check.Date.IsZero() {
+		check.Date = time.Now()
+	}
+	checkDate := check.Date.In(location)
+	check.ConclusionStyle = checkConclusionStyle(check.Conclusion)
+	shortSHA := sha[:7]
+
+	var data = map[string]interface{}{
+		"Check":            check,
+		"Repo":             repo,
+		"ShortSHA":         shortSHA,
+		"CommitURL":        *repo.HTMLURL + "/commit/" + sha,
+		"CheckDisplayDate": safeFormattedDate(checkDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("check", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *sender.Login
+	message := &Email{
+		SenderName:     check.Name,
+		SenderUserName: emailUserName(senderUserName),
+		// Replaced with the commit's thread subject by threadEmails.
+		Subject:  fmt.Sprintf("Re: [%s] %s", *repo.FullName, shortSHA),
+		HTMLBody: htmlBody,
+		TextBody: textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		ReplyThreadKeys: []string{sha},
+	}, nil
+}
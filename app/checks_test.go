@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func handleTestCheckPayload(t *testing.T, handler EventHandler, fileName string) *EventResult {
+	file := openTestPayload(t, fileName)
+	defer file.Close()
+	result, err := handler.Handle(file, testContext)
+	if err != nil {
+		t.Fatalf("%s: %s", fileName, err)
+	}
+	return result
+}
+
+func TestStatusReply(t *testing.T) {
+	// Only commits that have been emailed have a thread to reply to.
+	result := handleTestCheckPayload(t, statusEventHandler{}, "status.json")
+	if len(result.Emails) != 0 {
+		t.Fatalf("got %d emails for an unmailed commit", len(result.Emails))
+	}
+
+	sha := "5555555555555555555555555555555555555555"
+	createThread(sha, "[o/r] 5555555: Status commit", "<status@example.com>", testContext)
+	result = handleTestCheckPayload(t, statusEventHandler{}, "status.json")
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	if len(result.NewThreadKeys) != 0 || !reflect.DeepEqual(result.ReplyThreadKeys, []string{sha}) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	email := result.Emails[0]
+	if email.SenderName != "ci/jenkins" {
+		t.Errorf("got sender %q", email.SenderName)
+	}
+	for _, s := range []string{">error<", "https://ci/build/12"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestCheckRunIgnored(t *testing.T) {
+	// Check runs are reported by their check suite instead.
+	createThread("cccccccccccccccccccccccccccccccccccccccc", "[o/r] ccccccc: Check commit", "<check@example.com>", testContext)
+	if result := handleTestCheckPayload(t, checkRunEventHandler{}, "check_run.json"); len(result.Emails) != 0 {
+		t.Errorf("got %d emails", len(result.Emails))
+	}
+}
+
+func TestCheckSuiteReply(t *testing.T) {
+	createThread("cccccccccccccccccccccccccccccccccccccccc", "[o/r] ccccccc: Check commit", "<check@example.com>", testContext)
+	result := handleTestCheckPayload(t, checkSuiteEventHandler{}, "check_suite.json")
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	// Apps' bot logins aren't valid in the sender's address as is.
+	if userName := result.Emails[0].SenderUserName; userName != "ci-app-bot" {
+		t.Errorf("got sender user name %q", userName)
+	}
+	// Suites link to all of the commit's checks.
+	if url := "https://github.com/o/r/commit/cccccccccccccccccccccccccccccccccccccccc/checks"; !strings.Contains(result.Emails[0].HTMLBody, url) {
+		t.Errorf("body does not contain %q", url)
+	}
+
+	// With a token, the runs that failed are listed.
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/check-suites/5/check-runs": "check-runs.json",
+	})()
+	result = handleTestCheckPayload(t, checkSuiteEventHandler{}, "check_suite.json")
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	for _, s := range []string{"build", "2 tests failed"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+	for _, body := range []string{email.HTMLBody, email.TextBody} {
+		if strings.Contains(body, "lint") {
+			t.Errorf("successful run is listed:\n%s", body)
+		}
+	}
+}
+
+func TestCheckSuiteGitHubActions(t *testing.T) {
+	// GitHub Actions suites are reported by their workflow runs instead.
+	createThread("cccccccccccccccccccccccccccccccccccccccc", "[o/r] ccccccc: Check commit", "<check@example.com>", testContext)
+	if result := handleTestCheckPayload(t, checkSuiteEventHandler{}, "check_suite-actions.json"); len(result.Emails) != 0 {
+		t.Errorf("got %d emails", len(result.Emails))
+	}
+}
+
+func TestCheckNotificationPolicy(t *testing.T) {
+	sha := "5555555555555555555555555555555555555555"
+	createThread(sha, "[o/r] 5555555: Status commit", "<status@example.com>", testContext)
+	var payload StatusPayload
+	loadTestPayload(t, "status.json", &payload)
+	check := DisplayCheck{Name: "ci/jenkins", Conclusion: "success"}
+
+	result, err := handleCheck(check, sha, payload.Repo, payload.Sender, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 0 {
+		t.Errorf("got %d emails for a success by default", len(result.Emails))
+	}
+
+	defer withHookConfig(HookConfig{CheckNotificationPolicy: "all"})()
+	result, err = handleCheck(check, sha, payload.Repo, payload.Sender, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Errorf("got %d emails for a success with the all policy", len(result.Emails))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type commitCommentEventHandler struct{}
+
+func init() {
+	registerEventHandler("commit_comment", commitCommentEventHandler{})
+}
+
+func (commitCommentEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload CommitCommentPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleCommitCommentPayload(payload, c)
+}
+
+func handleCommitCommentPayload(payload CommitCommentPayload, c context.Context) (*EventResult, error) {
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	updatedDate := payload.Comment.UpdatedAt.In(location)
+
+	commitSHA := *payload.Comment.CommitID
+	commitShortSHA := commitSHA[:7]
+	commitURL := *payload.Repo.HTMLURL + "/commit/" + commitSHA
+
+	body := *payload.Comment.Body
+	if len(body) > 0 {
+		body = renderMessageMarkdown(body, payload.Repo, c)
+	}
+
+	var data = map[string]interface{}{
+		"Payload":            payload,
+		"Comment":            payload.Comment,
+		"Sender":             payload.Sender,
+		"Repo":               payload.Repo,
+		"ShortSHA":           commitShortSHA,
+		"Body":               body,
+		"CommitURL":          commitURL,
+		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
+	}
+
+	htmlBody, textBody, err := renderEmailBodies("commit-comment", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	senderName := senderUserName
+
+	// Replaced with the commit's thread subject (if we have one) by
+	// threadEmails.
+	subject := fmt.Sprintf("Re: [%s] %s", *payload.Repo.FullName, commitShortSHA)
+
+	message := &Email{
+		SenderName:     senderName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        subject,
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		ReplyThreadKeys: []string{commitSHA},
+	}, nil
+}
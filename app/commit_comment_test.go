@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommitCommentEmail(t *testing.T) {
+	var payload CommitCommentPayload
+	loadTestPayload(t, "commit_comment.json", &payload)
+	result, err := handleCommitCommentPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sha = "3333333333333333333333333333333333333333"
+	if len(result.Emails) != 1 || !reflect.DeepEqual(result.ReplyThreadKeys, []string{sha}) {
+		t.Fatalf("got %d emails and reply thread keys %v", len(result.Emails), result.ReplyThreadKeys)
+	}
+	// The comment is rendered locally, with its HTML escaped and its
+	// references linked.
+	body := result.Emails[0].HTMLBody
+	for _, s := range []string{"&lt;nil&gt;", `href="https://github.com/o/r/issues/12"`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+)
+
+const (
+	defaultDeliveryRetentionDays = 30
+	defaultMaxDeliveryAttempts   = 5
+	// Entities are limited to 1MB, so payloads that are bigger than this are
+	// split up into HookDeliveryPayloadChunk entities.
+	deliveryPayloadChunkSize = 900 * 1024
+	// How long a delivery that is being processed is left alone before it's
+	// assumed that whatever was processing it went away.
+	deliveryClaimLease = 10 * time.Minute
+)
+
+// The queue that deliveries are processed on (see queue.yaml). A variable so
+// that tests, which don't have queue.yaml, can use the default queue.
+var deliveryQueueName = "deliveries"
+
+const (
+	DeliveryPending    = "pending"
+	DeliveryProcessing = "processing"
+	DeliveryDone       = "done"
+	DeliveryDead       = "dead"
+)
+
+// HookDelivery records a webhook delivery (keyed by its X-GitHub-Delivery ID).
+// The payload is kept so that it can be processed in the background (and
+// retried if need be) and replayed later, and the record itself means that
+// redeliveries don't result in duplicate emails.
+type HookDelivery struct {
+	EventType  string `datastore:",noindex"`
+	ReceivedAt time.Time
+	Payload    []byte `datastore:",noindex"`
+	// If the payload was too big to be stored in Payload, the number of
+	// HookDeliveryPayloadChunk (child) entities that it was split into.
+	PayloadChunks int `datastore:",noindex"`
+	// JSON-encoded request headers.
+	Headers   []byte `datastore:",noindex"`
+	Status    string
+	Attempts  int       `datastore:",noindex"`
+	LastError string    `datastore:",noindex"`
+	ClaimedAt time.Time `datastore:",noindex"`
+	// Message IDs of the emails that have been sent for the delivery, so that
+	// retries don't send them again.
+	SentMessageIDs []string `datastore:",noindex"`
+}
+
+// HookDeliveryPayloadChunk is part of a payload that was too big to be stored
+// in its HookDelivery. ReceivedAt is the delivery's, so that chunks can be
+// expired along with it.
+type HookDeliveryPayloadChunk struct {
+	ReceivedAt time.Time
+	Data       []byte `datastore:",noindex"`
+}
+
+func (delivery *HookDelivery) DecodedHeaders() http.Header {
+	headers := make(http.Header)
+	if len(delivery.Headers) > 0 {
+		json.Unmarshal(delivery.Headers, &headers)
+	}
+	return headers
+}
+
+// DisplayHookDelivery is a HookDelivery along with its ID, for use in admin
+// pages.
+type DisplayHookDelivery struct {
+	ID string
+	*HookDelivery
+}
+
+func hookDeliveryKey(deliveryId string, c context.Context) *datastore.Key {
+	return datastore.NewKey(c, "HookDelivery", deliveryId, 0, nil)
+}
+
+func getHookDelivery(deliveryId string, c context.Context) *HookDelivery {
+	delivery := new(HookDelivery)
+	err := datastore.Get(c, hookDeliveryKey(deliveryId, c), delivery)
+	if err != nil {
+		return nil
+	}
+	return delivery
+}
+
+func hookDeliveryPayloadChunkKey(deliveryKey *datastore.Key, index int, c context.Context) *datastore.Key {
+	return datastore.NewKey(c, "HookDeliveryPayloadChunk", "", int64(index+1), deliveryKey)
+}
+
+// putHookDeliveryPayloadChunks stores the payload in chunks (if it's too big
+// to be stored in the delivery itself), returning how many there are.
+func putHookDeliveryPayloadChunks(deliveryKey *datastore.Key, payload []byte, receivedAt time.Time, c context.Context) (int, error) {
+	if len(payload) <= deliveryPayloadChunkSize {
+		return 0, nil
+	}
+	chunkCount := 0
+	for start := 0; start < len(payload); start += deliveryPayloadChunkSize {
+		end := start + deliveryPayloadChunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := &HookDeliveryPayloadChunk{
+			ReceivedAt: receivedAt,
+			Data:       payload[start:end],
+		}
+		// One at a time, since the RPC size is limited too.
+		if _, err := datastore.Put(c, hookDeliveryPayloadChunkKey(deliveryKey, chunkCount, c), chunk); err != nil {
+			return 0, err
+		}
+		chunkCount++
+	}
+	return chunkCount, nil
+}
+
+// hookDeliveryPayload returns the delivery's payload, loading it from its
+// chunks if need be.
+func hookDeliveryPayload(deliveryKey *datastore.Key, delivery *HookDelivery, c context.Context) ([]byte, error) {
+	if delivery.PayloadChunks == 0 {
+		return delivery.Payload, nil
+	}
+	var payload []byte
+	for i := 0; i < delivery.PayloadChunks; i++ {
+		chunk := new(HookDeliveryPayloadChunk)
+		if err := datastore.Get(c, hookDeliveryPayloadChunkKey(deliveryKey, i, c), chunk); err != nil {
+			return nil, fmt.Errorf("could not load payload chunk %d: %s", i, err)
+		}
+		payload = append(payload, chunk.Data...)
+	}
+	return payload, nil
+}
+
+func newProcessDeliveryTask(deliveryId string) *taskqueue.Task {
+	return taskqueue.NewPOSTTask("/tasks/process-delivery", url.Values{
+		"delivery_id": {deliveryId},
+	})
+}
+
+// enqueueHookDelivery stores the payload and queues a task to process it. If
+// the delivery has been seen before, nothing is done and queued is false.
+func enqueueHookDelivery(deliveryId string, eventType string, payload []byte, headers http.Header, c context.Context) (queued bool, err error) {
+	key := hookDeliveryKey(deliveryId, c)
+	headersJson, err := json.Marshal(headers)
+	if err != nil {
+		return false, err
+	}
+	receivedAt := time.Now()
+	// Chunks are written ahead of (and outside of) the transaction, since
+	// they may be too big for it. If this turns out to be a redelivery, they
+	// just overwrite the identical chunks of the original.
+	payloadChunks, err := putHookDeliveryPayloadChunks(key, payload, receivedAt, c)
+	if err != nil {
+		return false, err
+	}
+	if payloadChunks > 0 {
+		payload = nil
+	}
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		var existing HookDelivery
+		err := datastore.Get(tc, key, &existing)
+		if err == nil {
+			queued = false
+			return nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		delivery := &HookDelivery{
+			EventType:     eventType,
+			ReceivedAt:    receivedAt,
+			Payload:       payload,
+			PayloadChunks: payloadChunks,
+			Headers:       headersJson,
+			Status:        DeliveryPending,
+		}
+		if _, err := datastore.Put(tc, key, delivery); err != nil {
+			return err
+		}
+		if _, err := taskqueue.Add(tc, newProcessDeliveryTask(deliveryId), deliveryQueueName); err != nil {
+			return err
+		}
+		queued = true
+		return nil
+	}, nil)
+	return queued, err
+}
+
+func maxDeliveryAttempts() int {
+	if hookConfig.MaxDeliveryAttempts > 0 {
+		return hookConfig.MaxDeliveryAttempts
+	}
+	return defaultMaxDeliveryAttempts
+}
+
+// claimHookDelivery marks a pending delivery as being processed (and counts
+// the attempt), so that concurrent runs of its task (the task queue may run a
+// task more than once) don't both send its emails. Deliveries that are
+// already being processed are only claimed once their lease has expired.
+func claimHookDelivery(key *datastore.Key, c context.Context) (delivery *HookDelivery, claimed bool, err error) {
+	delivery = new(HookDelivery)
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		claimed = false
+		if err := datastore.Get(tc, key, delivery); err != nil {
+			return err
+		}
+		now := time.Now()
+		if delivery.Status == DeliveryProcessing {
+			if now.Before(delivery.ClaimedAt.Add(deliveryClaimLease)) {
+				return nil
+			}
+		} else if delivery.Status != DeliveryPending {
+			return nil
+		}
+		delivery.Status = DeliveryProcessing
+		delivery.ClaimedAt = now
+		delivery.Attempts++
+		if _, err := datastore.Put(tc, key, delivery); err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	}, nil)
+	return delivery, claimed, err
+}
+
+// processDeliveryHandler is invoked by the task queue. Returning an error
+// status makes the queue retry the task with exponential backoff (see
+// queue.yaml), until the delivery has used up its attempts and is moved to
+// the dead-letter list.
+func processDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-AppEngine-QueueName") == "" && !appengine.IsDevAppServer() {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	c := appengine.NewContext(r)
+	deliveryId := r.FormValue("delivery_id")
+	key := hookDeliveryKey(deliveryId, c)
+	delivery, claimed, err := claimHookDelivery(key, c)
+	if err == datastore.ErrNoSuchEntity {
+		log.Errorf(c, "Could not load delivery %s: %s", deliveryId, err)
+		// Don't retry, the delivery may have expired.
+		fmt.Fprint(w, "No such delivery")
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Could not claim delivery %s: %s", deliveryId, err)
+		http.Error(w, "Could not claim delivery", http.StatusInternalServerError)
+		return
+	}
+	if !claimed && delivery.Status == DeliveryProcessing {
+		// Have the queue check back later, in case whatever is processing it
+		// doesn't finish.
+		log.Infof(c, "Delivery %s is already being processed", deliveryId)
+		http.Error(w, "Already being processed", http.StatusConflict)
+		return
+	}
+	if !claimed {
+		log.Infof(c, "Delivery %s is %s, skipping", deliveryId, delivery.Status)
+		fmt.Fprint(w, "Already processed")
+		return
+	}
+
+	payload, err := hookDeliveryPayload(key, delivery, c)
+	if err == nil {
+		delivery.SentMessageIDs, err = deliverPayload(delivery.EventType, payload, delivery.SentMessageIDs, c)
+	}
+	delivery.ClaimedAt = time.Time{}
+	if err == nil {
+		delivery.Status = DeliveryDone
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= maxDeliveryAttempts() {
+			delivery.Status = DeliveryDead
+		} else {
+			delivery.Status = DeliveryPending
+		}
+	}
+	if _, putErr := datastore.Put(c, key, delivery); putErr != nil {
+		log.Errorf(c, "Could not update delivery %s: %s", deliveryId, putErr)
+	}
+
+	if err == nil {
+		fmt.Fprint(w, "OK")
+		return
+	}
+	if delivery.Status == DeliveryDead {
+		log.Errorf(c, "Giving up on %s delivery %s after %d attempts: %s",
+			delivery.EventType, deliveryId, delivery.Attempts, err)
+		fmt.Fprint(w, "Moved to dead-letter list")
+		return
+	}
+	log.Warningf(c, "Attempt %d of %s delivery %s failed: %s",
+		delivery.Attempts, delivery.EventType, deliveryId, err)
+	http.Error(w, "Delivery failed", http.StatusInternalServerError)
+}
+
+// deadLettersHandler lists deliveries that could not be processed, and allows
+// them to be retried.
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	if r.Method == "POST" {
+		deliveryId := r.FormValue("delivery_id")
+		key := hookDeliveryKey(deliveryId, c)
+		err := datastore.RunInTransaction(c, func(tc context.Context) error {
+			delivery := new(HookDelivery)
+			if err := datastore.Get(tc, key, delivery); err != nil {
+				return err
+			}
+			if delivery.Status != DeliveryDead {
+				return fmt.Errorf("delivery is %s", delivery.Status)
+			}
+			delivery.Status = DeliveryPending
+			delivery.Attempts = 0
+			if _, err := datastore.Put(tc, key, delivery); err != nil {
+				return err
+			}
+			_, err := taskqueue.Add(tc, newProcessDeliveryTask(deliveryId), deliveryQueueName)
+			return err
+		}, nil)
+		if err != nil {
+			log.Errorf(c, "Could not retry delivery %s: %s", deliveryId, err)
+			http.Error(w, "Could not retry delivery", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/dead-letters", http.StatusSeeOther)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var deliveries []*HookDelivery
+	keys, err := datastore.NewQuery("HookDelivery").
+		Filter("Status =", DeliveryDead).
+		Order("-ReceivedAt").
+		Limit(100).
+		GetAll(c, &deliveries)
+	if err != nil {
+		log.Errorf(c, "Could not query dead deliveries: %s", err)
+		http.Error(w, "Could not query dead deliveries", http.StatusInternalServerError)
+		return
+	}
+	displayDeliveries := make([]DisplayHookDelivery, 0, len(deliveries))
+	for i, delivery := range deliveries {
+		payload, err := hookDeliveryPayload(keys[i], delivery, c)
+		if err != nil {
+			log.Warningf(c, "Could not load delivery %s payload: %s", keys[i].StringID(), err)
+		}
+		delivery.Payload = payload
+		displayDeliveries = append(displayDeliveries, DisplayHookDelivery{
+			ID:           keys[i].StringID(),
+			HookDelivery: delivery,
+		})
+	}
+	var data = map[string]interface{}{
+		"Deliveries": displayDeliveries,
+	}
+	templates["dead-letters"].Execute(w, data)
+}
+
+// deliveriesHandler lists the most recently received deliveries, so that
+// their payloads can be inspected and replayed.
+func deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	var deliveries []*HookDelivery
+	keys, err := datastore.NewQuery("HookDelivery").
+		Order("-ReceivedAt").
+		Limit(100).
+		GetAll(c, &deliveries)
+	if err != nil {
+		log.Errorf(c, "Could not query deliveries: %s", err)
+		http.Error(w, "Could not query deliveries", http.StatusInternalServerError)
+		return
+	}
+	displayDeliveries := make([]DisplayHookDelivery, 0, len(deliveries))
+	for i, delivery := range deliveries {
+		displayDeliveries = append(displayDeliveries, DisplayHookDelivery{
+			ID:           keys[i].StringID(),
+			HookDelivery: delivery,
+		})
+	}
+	var data = map[string]interface{}{
+		"Deliveries": displayDeliveries,
+	}
+	templates["deliveries"].Execute(w, data)
+}
+
+// replayHandler runs an archived payload through handlePayload again, either
+// only previewing the resulting email (like the hook test harness) or
+// actually sending it.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	deliveryId := r.FormValue("delivery_id")
+	delivery := getHookDelivery(deliveryId, c)
+	if delivery == nil {
+		http.Error(w, "No such delivery", http.StatusNotFound)
+		return
+	}
+	payload, err := hookDeliveryPayload(hookDeliveryKey(deliveryId, c), delivery, c)
+	if err != nil {
+		log.Errorf(c, "Could not load delivery %s payload: %s", deliveryId, err)
+		http.Error(w, "Could not load payload", http.StatusInternalServerError)
+		return
+	}
+	delivery.Payload = payload
+	var data = map[string]interface{}{
+		"Delivery": DisplayHookDelivery{ID: deliveryId, HookDelivery: delivery},
+	}
+	if r.Method == "POST" {
+		mode := r.FormValue("mode")
+		data["Mode"] = mode
+		if mode == "preview" {
+			result, err := handlePayload(delivery.EventType, bytes.NewReader(delivery.Payload), c)
+			data["Result"] = result
+			data["MessageErr"] = err
+		} else if mode == "send" {
+			log.Infof(c, "Replaying %s delivery %s", delivery.EventType, deliveryId)
+			// Replays send every email again, regardless of what the
+			// original delivery sent.
+			_, sendErr := deliverPayload(delivery.EventType, delivery.Payload, nil, c)
+			data["SendErr"] = sendErr
+			data["Sent"] = true
+		} else {
+			http.Error(w, "Unknown replay mode", http.StatusBadRequest)
+			return
+		}
+	} else if r.Method != "GET" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	templates["replay"].Execute(w, data)
+}
+
+func deliveryRetention() time.Duration {
+	days := hookConfig.DeliveryRetentionDays
+	if days <= 0 {
+		days = defaultDeliveryRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// deleteKeys deletes the entities with the given keys, in batches (datastore
+// limits how many entities can be deleted in a single call).
+func deleteKeys(keys []*datastore.Key, c context.Context) error {
+	for start := 0; start < len(keys); start += 500 {
+		end := start + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := datastore.DeleteMulti(c, keys[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expireDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Appengine-Cron") != "true" && !appengine.IsDevAppServer() {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	c := appengine.NewContext(r)
+	cutoff := time.Now().Add(-deliveryRetention())
+	keys, err := datastore.NewQuery("HookDelivery").
+		Filter("ReceivedAt <", cutoff).
+		KeysOnly().
+		GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "Could not query expired deliveries: %s", err)
+		http.Error(w, "Could not query expired deliveries", http.StatusInternalServerError)
+		return
+	}
+	chunkKeys, err := datastore.NewQuery("HookDeliveryPayloadChunk").
+		Filter("ReceivedAt <", cutoff).
+		KeysOnly().
+		GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "Could not query expired payload chunks: %s", err)
+		http.Error(w, "Could not query expired payload chunks", http.StatusInternalServerError)
+		return
+	}
+	if err := deleteKeys(append(keys, chunkKeys...), c); err != nil {
+		log.Errorf(c, "Could not delete expired deliveries: %s", err)
+		http.Error(w, "Could not delete expired deliveries", http.StatusInternalServerError)
+		return
+	}
+	log.Infof(c, "Expired %d deliveries (and %d payload chunks) received before %s",
+		len(keys), len(chunkKeys), cutoff)
+	fmt.Fprintf(w, "Expired %d deliveries", len(keys))
+}
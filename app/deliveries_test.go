@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func signedHookRequest(eventType string, deliveryId string, body string, secret string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	r := newTestRequest("POST", "/hook", strings.NewReader(body))
+	r.Header.Set("X-Github-Event", eventType)
+	r.Header.Set("X-GitHub-Delivery", deliveryId)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestRedeliveryIgnored(t *testing.T) {
+	defer withHookConfig(HookConfig{WebhookSecrets: map[string]string{"o/r": "secret"}})()
+	body := `{"repository":{"full_name":"o/r"}}`
+	w := httptest.NewRecorder()
+	hookHandler(w, signedHookRequest("push", "redelivered-delivery", body, "secret"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("first delivery: got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	hookHandler(w, signedHookRequest("push", "redelivered-delivery", body, "secret"))
+	if w.Code != http.StatusOK || w.Body.String() != "Already handled" {
+		t.Errorf("redelivery: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func putTestHookDelivery(deliveryId string, age time.Duration) *datastore.Key {
+	key := datastore.NewKey(testContext, "HookDelivery", deliveryId, 0, nil)
+	delivery := &HookDelivery{EventType: "push", ReceivedAt: time.Now().Add(-age)}
+	if _, err := datastore.Put(testContext, key, delivery); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func TestExpireDeliveries(t *testing.T) {
+	defer withHookConfig(HookConfig{DeliveryRetentionDays: 7})()
+	expired := putTestHookDelivery("expired-delivery", 8*24*time.Hour)
+	kept := putTestHookDelivery("kept-delivery", 6*24*time.Hour)
+	// Payload chunks are expired along with their deliveries.
+	largePayload := bytes.Repeat([]byte("x"), deliveryPayloadChunkSize+1)
+	for _, key := range []*datastore.Key{expired, kept} {
+		delivery := getHookDelivery(key.StringID(), testContext)
+		if _, err := putHookDeliveryPayloadChunks(key, largePayload, delivery.ReceivedAt, testContext); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newTestRequest("GET", "/cron/expire-deliveries", nil)
+	r.Header.Set("X-Appengine-Cron", "true")
+	w := httptest.NewRecorder()
+	expireDeliveriesHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if err := datastore.Get(testContext, expired, new(HookDelivery)); err != datastore.ErrNoSuchEntity {
+		t.Errorf("expired delivery: got %v", err)
+	}
+	if err := datastore.Get(testContext, kept, new(HookDelivery)); err != nil {
+		t.Errorf("delivery within the retention window: got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := datastore.Get(testContext, hookDeliveryPayloadChunkKey(expired, i, testContext), new(HookDeliveryPayloadChunk)); err != datastore.ErrNoSuchEntity {
+			t.Errorf("expired payload chunk %d: got %v", i, err)
+		}
+		if err := datastore.Get(testContext, hookDeliveryPayloadChunkKey(kept, i, testContext), new(HookDeliveryPayloadChunk)); err != nil {
+			t.Errorf("payload chunk %d within the retention window: got %v", i, err)
+		}
+	}
+}
+
+func TestLargeDeliveryPayload(t *testing.T) {
+	// Bigger than an entity can be.
+	payload := make([]byte, 2*1024*1024+1)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	if _, err := enqueueHookDelivery("large-delivery", "push", payload, nil, testContext); err != nil {
+		t.Fatal(err)
+	}
+	key := hookDeliveryKey("large-delivery", testContext)
+	delivery := getHookDelivery("large-delivery", testContext)
+	if len(delivery.Payload) != 0 || delivery.PayloadChunks != 3 {
+		t.Errorf("got %d byte payload in %d chunks", len(delivery.Payload), delivery.PayloadChunks)
+	}
+	loaded, err := hookDeliveryPayload(key, delivery, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, payload) {
+		t.Errorf("got %d bytes back, want the original %d", len(loaded), len(payload))
+	}
+}
+
+func TestDeliveryRetention(t *testing.T) {
+	defer withHookConfig(HookConfig{})()
+	if got := deliveryRetention(); got != 30*24*time.Hour {
+		t.Errorf("default: got %s", got)
+	}
+	hookConfig.DeliveryRetentionDays = 2
+	if got := deliveryRetention(); got != 48*time.Hour {
+		t.Errorf("configured: got %s", got)
+	}
+}
+
+func processTestDelivery(deliveryId string) *httptest.ResponseRecorder {
+	r := newTestRequest("POST", "/tasks/process-delivery", strings.NewReader("delivery_id="+deliveryId))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-AppEngine-QueueName", deliveryQueueName)
+	w := httptest.NewRecorder()
+	processDeliveryHandler(w, r)
+	return w
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	defer withHookConfig(HookConfig{MaxDeliveryAttempts: 3})()
+	// Not a valid push payload, so every attempt fails.
+	if _, err := enqueueHookDelivery("failing-delivery", "push", []byte("not json"), nil, testContext); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		w := processTestDelivery("failing-delivery")
+		delivery := getHookDelivery("failing-delivery", testContext)
+		if delivery.Attempts != attempt || len(delivery.LastError) == 0 {
+			t.Errorf("attempt %d: got %d attempts, error %q", attempt, delivery.Attempts, delivery.LastError)
+		}
+		if attempt < 3 {
+			// An error status has the queue retry the task.
+			if w.Code != http.StatusInternalServerError || delivery.Status != DeliveryPending {
+				t.Errorf("attempt %d: got %d, %s", attempt, w.Code, delivery.Status)
+			}
+		} else if w.Code != http.StatusOK || delivery.Status != DeliveryDead {
+			t.Errorf("last attempt: got %d, %s", w.Code, delivery.Status)
+		}
+	}
+	if w := processTestDelivery("failing-delivery"); w.Code != http.StatusOK || w.Body.String() != "Already processed" {
+		t.Errorf("dead delivery: got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Dead deliveries are listed, and can be retried from there.
+	w := httptest.NewRecorder()
+	deadLettersHandler(w, newTestRequest("GET", "/admin/dead-letters", nil))
+	if !strings.Contains(w.Body.String(), "failing-delivery") {
+		t.Errorf("dead delivery isn't listed:\n%s", w.Body.String())
+	}
+	r := newTestRequest("POST", "/admin/dead-letters", strings.NewReader("delivery_id=failing-delivery"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	deadLettersHandler(w, r)
+	delivery := getHookDelivery("failing-delivery", testContext)
+	if w.Code != http.StatusSeeOther || delivery.Status != DeliveryPending || delivery.Attempts != 0 {
+		t.Errorf("retry: got %d, %s after %d attempts", w.Code, delivery.Status, delivery.Attempts)
+	}
+}
+
+func TestDeliveryClaimLease(t *testing.T) {
+	// Not a valid push payload, so processing fails (and releases the claim).
+	if _, err := enqueueHookDelivery("claimed-delivery", "push", []byte("not json"), nil, testContext); err != nil {
+		t.Fatal(err)
+	}
+	key := hookDeliveryKey("claimed-delivery", testContext)
+	delivery, claimed, err := claimHookDelivery(key, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claimed || delivery.Status != DeliveryProcessing || delivery.Attempts != 1 {
+		t.Fatalf("first claim: got %v with %+v", claimed, delivery)
+	}
+
+	// Within the lease, the delivery can't be claimed again, and the task is
+	// retried later.
+	if _, claimed, err := claimHookDelivery(key, testContext); err != nil || claimed {
+		t.Errorf("second claim: got %v, %v", claimed, err)
+	}
+	if w := processTestDelivery("claimed-delivery"); w.Code != http.StatusConflict {
+		t.Errorf("processing within the lease: got %d", w.Code)
+	}
+
+	// Once the lease has expired, it's assumed that the first claim went away.
+	delivery.ClaimedAt = time.Now().Add(-deliveryClaimLease - time.Minute)
+	if _, err := datastore.Put(testContext, key, delivery); err != nil {
+		t.Fatal(err)
+	}
+	if w := processTestDelivery("claimed-delivery"); w.Code != http.StatusInternalServerError {
+		t.Errorf("processing after the lease: got %d", w.Code)
+	}
+	delivery = getHookDelivery("claimed-delivery", testContext)
+	if delivery.Status != DeliveryPending || delivery.Attempts != 2 || !delivery.ClaimedAt.IsZero() {
+		t.Errorf("got %+v", delivery)
+	}
+}
+
+// resumeTestEventHandler generates three emails for every event, the first of
+// which starts a thread.
+type resumeTestEventHandler struct{}
+
+func (resumeTestEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	result := &EventResult{NewThreadKeys: []string{"resume-thread"}}
+	for i := 1; i <= 3; i++ {
+		result.Emails = append(result.Emails, &Email{Subject: fmt.Sprintf("Email %d", i)})
+	}
+	return result, nil
+}
+
+func TestDeliveryResumesAfterSentEmails(t *testing.T) {
+	registerEventHandler("resume_test", resumeTestEventHandler{})
+	defer delete(eventHandlers, "resume_test")
+	var sentSubjects []string
+	failingSubject := "Email 2"
+	savedSend := sendDeliveryEmail
+	sendDeliveryEmail = func(email *Email, c context.Context) (string, string, error) {
+		if email.Subject == failingSubject {
+			failingSubject = ""
+			return "", "", errors.New("mail service unavailable")
+		}
+		sentSubjects = append(sentSubjects, email.Subject)
+		return "Queued", fmt.Sprintf("<resume-%d@example.com>", len(sentSubjects)), nil
+	}
+	defer func() { sendDeliveryEmail = savedSend }()
+	if _, err := enqueueHookDelivery("resumed-delivery", "resume_test", []byte("{}"), nil, testContext); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := processTestDelivery("resumed-delivery"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("first attempt: got %d", w.Code)
+	}
+	thread := getEmailThread("resume-thread", testContext)
+	if thread == nil || thread.MessageID != "<resume-1@example.com>" {
+		t.Fatalf("got thread %+v", thread)
+	}
+	// If the retry started the thread again, it would come back.
+	if err := datastore.Delete(testContext, datastore.NewKey(testContext, "EmailThread", "resume-thread", 0, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := processTestDelivery("resumed-delivery"); w.Code != http.StatusOK {
+		t.Fatalf("second attempt: got %d", w.Code)
+	}
+	if want := []string{"Email 1", "Email 2", "Email 3"}; strings.Join(sentSubjects, ",") != strings.Join(want, ",") {
+		t.Errorf("sent %v, want %v", sentSubjects, want)
+	}
+	delivery := getHookDelivery("resumed-delivery", testContext)
+	if delivery.Status != DeliveryDone || len(delivery.SentMessageIDs) != 3 {
+		t.Errorf("got %s delivery with message IDs %v", delivery.Status, delivery.SentMessageIDs)
+	}
+	if thread := getEmailThread("resume-thread", testContext); thread != nil {
+		t.Errorf("thread was created again: %+v", thread)
+	}
+}
+
+func TestProcessMissingDelivery(t *testing.T) {
+	// Deliveries may have expired by the time their task runs, that
+	// shouldn't be retried.
+	if w := processTestDelivery("missing-delivery"); w.Code != http.StatusOK {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func replayTestPayload(repoFullName string) string {
+	return `{"zen":"Design for failure.","hook_id":31,
+		"hook":{"id":31,"active":true,"events":["push"]},
+		"repository":{"full_name":"` + repoFullName + `","html_url":"https://github.com/` + repoFullName + `"},
+		"sender":{"login":"alice"}}`
+}
+
+// receiveReplayTestDelivery has /hook receive (and archive) a ping delivery,
+// without processing it.
+func receiveReplayTestDelivery(t *testing.T, deliveryId string, repoFullName string) {
+	hookConfig.WebhookSecrets = map[string]string{repoFullName: "secret"}
+	r := signedHookRequest("ping", deliveryId, replayTestPayload(repoFullName), "secret")
+	r.Header.Set("User-Agent", "GitHub-Hookshot/abc123")
+	w := httptest.NewRecorder()
+	hookHandler(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func replayTestDelivery(deliveryId string, mode string) *httptest.ResponseRecorder {
+	r := newTestRequest("POST", "/admin/replay", strings.NewReader("delivery_id="+deliveryId+"&mode="+mode))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	replayHandler(w, r)
+	return w
+}
+
+func TestDeliveryHeadersArchived(t *testing.T) {
+	defer withHookConfig(HookConfig{})()
+	receiveReplayTestDelivery(t, "archived-delivery", "o/archived")
+	delivery := getHookDelivery("archived-delivery", testContext)
+	headers := delivery.DecodedHeaders()
+	if headers.Get("X-Github-Event") != "ping" || headers.Get("X-GitHub-Delivery") != "archived-delivery" ||
+		headers.Get("User-Agent") != "GitHub-Hookshot/abc123" {
+		t.Errorf("got %v", headers)
+	}
+	if delivery.EventType != "ping" || string(delivery.Payload) != replayTestPayload("o/archived") {
+		t.Errorf("got %s delivery: %s", delivery.EventType, delivery.Payload)
+	}
+}
+
+func TestReplayPreview(t *testing.T) {
+	defer withHookConfig(HookConfig{SendPingEmail: true})()
+	var emails []*Email
+	defer captureEmails(&emails)()
+	receiveReplayTestDelivery(t, "preview-delivery", "o/previewed")
+
+	w := replayTestDelivery("preview-delivery", "preview")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "[o/previewed] Hook set up") {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	// Previews don't send anything, or have any other effects.
+	if len(emails) != 0 {
+		t.Errorf("preview sent %d emails", len(emails))
+	}
+	delivery := getHookDelivery("preview-delivery", testContext)
+	if delivery.Status != DeliveryPending || delivery.Attempts != 0 {
+		t.Errorf("preview changed the delivery to %s after %d attempts", delivery.Status, delivery.Attempts)
+	}
+	key := datastore.NewKey(testContext, "Installation", "o/previewed", 0, nil)
+	if err := datastore.Get(testContext, key, new(Installation)); err != datastore.ErrNoSuchEntity {
+		t.Errorf("preview recorded the installation: %v", err)
+	}
+}
+
+func TestReplaySend(t *testing.T) {
+	defer withHookConfig(HookConfig{SendPingEmail: true})()
+	var emails []*Email
+	defer captureEmails(&emails)()
+	receiveReplayTestDelivery(t, "sent-delivery", "o/replayed")
+	if w := processTestDelivery("sent-delivery"); w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Replays run the archived payload through the handler for the archived
+	// event type again, even though the delivery was already processed.
+	w := replayTestDelivery("sent-delivery", "send")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if len(emails) != 2 || emails[1].Subject != "[o/replayed] Hook set up" {
+		t.Fatalf("got %d emails", len(emails))
+	}
+	installation := new(Installation)
+	key := datastore.NewKey(testContext, "Installation", "o/replayed", 0, nil)
+	if err := datastore.Get(testContext, key, installation); err != nil || installation.HookID != 31 {
+		t.Errorf("got %+v, %v", installation, err)
+	}
+
+	if w := replayTestDelivery("sent-delivery", "resend"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown mode: got %d", w.Code)
+	}
+}
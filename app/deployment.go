@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+// A deployment starts a thread (which lists the commits that it includes),
+// and its status changes are sent as replies to it.
+
+type deploymentEventHandler struct{}
+type deploymentStatusEventHandler struct{}
+
+func init() {
+	registerEventHandler("deployment", deploymentEventHandler{})
+	registerEventHandler("deployment_status", deploymentStatusEventHandler{})
+}
+
+func (deploymentEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload DeploymentPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleDeploymentPayload(payload, c)
+}
+
+func (deploymentStatusEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload DeploymentStatusPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleDeploymentStatusPayload(payload, c)
+}
+
+type DisplayDeploymentStatus struct {
+	State string
+	// One of the pull.state.* styles.
+	StateStyle  string
+	Description string
+	Creator     string
+	URL         string
+	DisplayDate string
+}
+
+func deploymentThreadKey(repo *WebHookRepository, deployment *WebHookDeployment) string {
+	return fmt.Sprintf("deployment/%s/%d", *repo.FullName, *deployment.ID)
+}
+
+func deploymentSubject(repo *WebHookRepository, deployment *WebHookDeployment) string {
+	return fmt.Sprintf("[%s] Deploy of %s to %s",
+		*repo.FullName, *deployment.Ref, *deployment.Environment)
+}
+
+func deploymentStateStyle(state string) string {
+	switch state {
+	case "success":
+		return "pull.state.open"
+	case "failure", "error":
+		return "pull.state.closed"
+	case "inactive":
+		return "pull.state.merged"
+	}
+	return "pull.state.edited"
+}
+
+// findPreviousDeployment returns the most recent deployment (to the same
+// environment) before the given one that was of a different commit, or nil if
+// there isn't one.
+func findPreviousDeployment(deployment *WebHookDeployment, deployments []WebHookDeployment) *WebHookDeployment {
+	var previous *WebHookDeployment
+	for i := range deployments {
+		candidate := &deployments[i]
+		if *candidate.ID == *deployment.ID || *candidate.SHA == *deployment.SHA {
+			continue
+		}
+		if !candidate.CreatedAt.Before(*deployment.CreatedAt) {
+			continue
+		}
+		if previous == nil || candidate.CreatedAt.After(*previous.CreatedAt) {
+			previous = candidate
+		}
+	}
+	return previous
+}
+
+func newDisplayDeploymentStatus(status *WebHookDeploymentStatus, location *time.Location) DisplayDeploymentStatus {
+	displayStatus := DisplayDeploymentStatus{
+		State:      *status.State,
+		StateStyle: deploymentStateStyle(*status.State),
+	}
+	if status.Description != nil {
+		displayStatus.Description = *status.Description
+	}
+	if status.Creator != nil {
+		displayStatus.Creator = *status.Creator.Login
+	}
+	if status.LogURL != nil && len(*status.LogURL) > 0 {
+		displayStatus.URL = *status.LogURL
+	} else if status.TargetURL != nil {
+		displayStatus.URL = *status.TargetURL
+	}
+	if status.CreatedAt != nil {
+		displayStatus.DisplayDate = safeFormattedDate(status.CreatedAt.In(location).Format(DisplayDateFormat))
+	}
+	return displayStatus
+}
+
+func handleDeploymentPayload(payload DeploymentPayload, c context.Context) (*EventResult, error) {
+	deployment := payload.Deployment
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	createdDate := deployment.CreatedAt.In(location)
+
+	var previousDeployment *WebHookDeployment
+	var previousShortSha string
+	var compareUrl string
+	var displayCommits []DisplayCommit
+	moreCommitCount := 0
+	deployments, err := fetchDeployments(payload.Repo, *deployment.Environment, c)
+	if err != nil {
+		log.Warningf(c, "Could not fetch deployments: %s", err)
+	} else {
+		previousDeployment = findPreviousDeployment(deployment, deployments)
+	}
+	if previousDeployment != nil {
+		previousShortSha = (*previousDeployment.SHA)[:7]
+		compareUrl = fmt.Sprintf("https://github.com/%s/compare/%s...%s",
+			*payload.Repo.FullName, *previousDeployment.SHA, *deployment.SHA)
+		displayCommits, moreCommitCount, err = newDisplayCommitsForComparison(
+			payload.Repo, *previousDeployment.SHA, *deployment.SHA, payload.Sender, location, c)
+		if err != nil {
+			log.Warningf(c, "Could not fetch commits since %s: %s", *previousDeployment.SHA, err)
+		}
+	}
+
+	creator := payload.Sender
+	if deployment.Creator != nil {
+		creator = deployment.Creator
+	}
+	var data = map[string]interface{}{
+		"Payload":                    payload,
+		"Deployment":                 deployment,
+		"Creator":                    creator,
+		"Repo":                       payload.Repo,
+		"ShortSHA":                   (*deployment.SHA)[:7],
+		"CommitURL":                  fmt.Sprintf("https://github.com/%s/commit/%s", *payload.Repo.FullName, *deployment.SHA),
+		"PreviousDeployment":         previousDeployment,
+		"PreviousDeploymentShortSHA": previousShortSha,
+		"CompareURL":                 compareUrl,
+		"Commits":                    displayCommits,
+		"MoreCommitCount":            moreCommitCount,
+		"CreatedDisplayDate":         safeFormattedDate(createdDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("deployment", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *creator.Login
+	threadKey := deploymentThreadKey(payload.Repo, deployment)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        deploymentSubject(payload.Repo, deployment),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
+
+func handleDeploymentStatusPayload(payload DeploymentStatusPayload, c context.Context) (*EventResult, error) {
+	deployment := payload.Deployment
+	status := payload.DeploymentStatus
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+
+	// Show all of the states that the deployment has gone through, not just
+	// the latest one.
+	var transitions []DisplayDeploymentStatus
+	statuses, err := fetchDeploymentStatuses(payload.Repo, *deployment.ID, c)
+	if err != nil {
+		log.Warningf(c, "Could not fetch statuses for deployment %d: %s", *deployment.ID, err)
+	}
+	// Statuses are listed newest first.
+	sawCurrent := false
+	for i := len(statuses) - 1; i >= 0; i-- {
+		displayStatus := newDisplayDeploymentStatus(&statuses[i], location)
+		if *statuses[i].ID == *status.ID {
+			// The payload's version is more complete.
+			displayStatus = newDisplayDeploymentStatus(status, location)
+			sawCurrent = true
+		}
+		transitions = append(transitions, displayStatus)
+		if sawCurrent {
+			// Later statuses will get their own emails.
+			break
+		}
+	}
+	if !sawCurrent {
+		transitions = append(transitions, newDisplayDeploymentStatus(status, location))
+	}
+	current := transitions[len(transitions)-1]
+
+	environmentUrl := ""
+	if status.EnvironmentURL != nil {
+		environmentUrl = *status.EnvironmentURL
+	}
+	var data = map[string]interface{}{
+		"Payload":        payload,
+		"Deployment":     deployment,
+		"Status":         current,
+		"Transitions":    transitions,
+		"Repo":           payload.Repo,
+		"ShortSHA":       (*deployment.SHA)[:7],
+		"CommitURL":      fmt.Sprintf("https://github.com/%s/commit/%s", *payload.Repo.FullName, *deployment.SHA),
+		"EnvironmentURL": environmentUrl,
+	}
+	htmlBody, textBody, err := renderEmailBodies("deployment-status", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	if status.Creator != nil {
+		senderUserName = *status.Creator.Login
+	}
+	threadKey := deploymentThreadKey(payload.Repo, deployment)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        "Re: " + deploymentSubject(payload.Repo, deployment),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestDeploymentEmail(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/deployments": "deployments.json",
+		"/repos/o/r/compare/1111111111111111111111111111111111111111...bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": "compare.json",
+	})()
+	var payload DeploymentPayload
+	loadTestPayload(t, "deployment.json", &payload)
+	result, err := handleDeploymentPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] Deploy of main to production" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	want := []string{"deployment/o/r/12"}
+	if !reflect.DeepEqual(result.NewThreadKeys, want) || !reflect.DeepEqual(result.ReplyThreadKeys, want) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	// The commits since the previous deployment to the environment (11, not
+	// the older 10) are included.
+	for _, s := range []string{"Weekly deploy", "1111111", "Dropped one"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestDeploymentStatusEmail(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/deployments/12/statuses": "deployment-statuses.json",
+	})()
+	var payload DeploymentStatusPayload
+	loadTestPayload(t, "deployment_status.json", &payload)
+	result, err := handleDeploymentStatusPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	// Status changes are replies to the deployment's email.
+	if len(result.NewThreadKeys) != 0 || !reflect.DeepEqual(result.ReplyThreadKeys, []string{"deployment/o/r/12"}) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	// The earlier statuses are shown as a timeline, without the ones that
+	// came after this one.
+	body := result.Emails[0].HTMLBody
+	for _, s := range []string{"Health check failed", "https://ci/log/3", ">queued<", ">in_progress<"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+	if strings.Contains(body, "inactive") {
+		t.Errorf("later status is shown:\n%s", body)
+	}
+}
+
+func TestComparisonMoreCommitCount(t *testing.T) {
+	// The API only lists the first 250 commits of big comparisons, but
+	// reports how many there are.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		author := map[string]string{"name": "Alice", "date": "2020-01-01T12:00:00Z"}
+		commits := make([]map[string]interface{}, 0)
+		for i := 0; i < 250; i++ {
+			commits = append(commits, map[string]interface{}{
+				"sha":      fmt.Sprintf("%040x", i+1),
+				"html_url": fmt.Sprintf("https://github.com/o/r/commit/%040x", i+1),
+				"commit": map[string]interface{}{
+					"message":   fmt.Sprintf("Commit %d", i),
+					"author":    author,
+					"committer": author,
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"total_commits": 400, "commits": commits})
+	}))
+	defer server.Close()
+	savedURL := gitHubAPIURL
+	gitHubAPIURL = server.URL
+	defer func() { gitHubAPIURL = savedURL }()
+
+	repoFullName := "o/r"
+	displayCommits, moreCommitCount, err := newDisplayCommitsForComparison(
+		&WebHookRepository{FullName: &repoFullName}, "v1", "v2", &github.User{}, time.UTC, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(displayCommits) != comparisonCommitLimit || moreCommitCount != 400-comparisonCommitLimit {
+		t.Errorf("got %d commits and %d more", len(displayCommits), moreCommitCount)
+	}
+	if displayCommits[len(displayCommits)-1].Title != "Commit 249" {
+		t.Errorf("got %q last, want the most recent listed commit", displayCommits[len(displayCommits)-1].Title)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+// The email limit is on the rendered HTML of the diffs, and leaves room for
+// the rest of the email under Gmail's ~102K clipping threshold.
+const (
+	defaultInlineDiffFileLimit  = 20 * 1024
+	defaultInlineDiffEmailLimit = 60 * 1024
+)
+
+// Roughly how much the markup around each diff line in files.html adds (on
+// top of its style).
+const diffLineMarkupSize = 40
+
+type DisplayDiffLineType int
+
+const (
+	DiffLineContext DisplayDiffLineType = iota
+	DiffLineAddition
+	DiffLineDeletion
+	DiffLineHunk
+)
+
+func (t DisplayDiffLineType) Style() string {
+	var style string
+	if t == DiffLineAddition {
+		style = "addition"
+	} else if t == DiffLineDeletion {
+		style = "deletion"
+	} else if t == DiffLineHunk {
+		style = "hunk"
+	} else {
+		style = "context"
+	}
+	return "commit.diff.line." + style
+}
+
+type DisplayDiffLine struct {
+	Type DisplayDiffLineType
+	Text string
+}
+
+func inlineDiffFileLimit() int {
+	if hookConfig.InlineDiffFileLimit > 0 {
+		return hookConfig.InlineDiffFileLimit
+	}
+	return defaultInlineDiffFileLimit
+}
+
+func inlineDiffEmailLimit() int {
+	if hookConfig.InlineDiffEmailLimit > 0 {
+		return hookConfig.InlineDiffEmailLimit
+	}
+	return defaultInlineDiffEmailLimit
+}
+
+func newDisplayDiffLines(patch string) []DisplayDiffLine {
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	diffLines := make([]DisplayDiffLine, 0, len(lines))
+	for _, line := range lines {
+		lineType := DiffLineContext
+		if strings.HasPrefix(line, "@@") {
+			lineType = DiffLineHunk
+		} else if strings.HasPrefix(line, "+") {
+			lineType = DiffLineAddition
+		} else if strings.HasPrefix(line, "-") {
+			lineType = DiffLineDeletion
+		}
+		diffLines = append(diffLines, DisplayDiffLine{Type: lineType, Text: line})
+	}
+	return diffLines
+}
+
+// renderedDiffSize estimates how big the diff lines will be once rendered as
+// HTML, since the styles and escaping make that several times the size of
+// the patch.
+func renderedDiffSize(diffLines []DisplayDiffLine) int {
+	size := 0
+	for _, line := range diffLines {
+		size += diffLineMarkupSize +
+			len(getStyle("commit.diff.line")) + len(getStyle(line.Type.Style())) +
+			len(template.HTMLEscapeString(line.Text))
+	}
+	return size
+}
+
+const commitDetailsWorkers = 8
+
+// fetchCommitDetails fetches the full versions of the commits (with their
+// files' patches and line counts), keyed by SHA. Commits that could not be
+// fetched are left out. Pushes may have up to PushCommitLimit commits, so
+// they're fetched concurrently (all of them are needed for the line counts,
+// even once the diffs' budget is used up).
+func fetchCommitDetails(displayCommits []DisplayCommit, repo *WebHookRepository, c context.Context) map[string]*ApiCommit {
+	details := make(map[string]*ApiCommit)
+	if len(displayCommits) == 0 {
+		return details
+	}
+	shas := make(chan string, len(displayCommits))
+	for _, displayCommit := range displayCommits {
+		shas <- displayCommit.SHA
+	}
+	close(shas)
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	workerCount := commitDetailsWorkers
+	if workerCount > len(displayCommits) {
+		workerCount = len(displayCommits)
+	}
+	for w := 0; w < workerCount; w++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for sha := range shas {
+				commit, err := fetchCommit(repo, sha, c)
+				if err != nil {
+					log.Warningf(c, "Could not fetch details of %s: %s", sha, err)
+					continue
+				}
+				mutex.Lock()
+				details[sha] = commit
+				mutex.Unlock()
+			}
+		}()
+	}
+	waitGroup.Wait()
+	return details
+}
+
+// addMissingFiles fills in the files of commits that don't have any from their
+// details. Commits from comparisons (rather than push payloads) don't come
+// with their files.
+func addMissingFiles(displayCommits []DisplayCommit, details map[string]*ApiCommit) {
+	for i := range displayCommits {
+		displayCommit := &displayCommits[i]
+		commit, ok := details[displayCommit.SHA]
+		if !ok || len(displayCommit.Files) > 0 {
+			continue
+		}
+		webHookCommit := commit.WebHookCommit()
+		webHookCommit.URL = &displayCommit.URL
+		displayCommit.Files = newDisplayCommitFiles(&webHookCommit)
+	}
+}
+
+// addInlineDiffs adds the patches of the commits to their files. Files whose
+// patch is too large (or that would put the email over its limit) only have
+// the link to the diff, as before.
+func addInlineDiffs(displayCommits []DisplayCommit, details map[string]*ApiCommit) {
+	budget := inlineDiffEmailLimit()
+	for i := range displayCommits {
+		displayCommit := &displayCommits[i]
+		commit, ok := details[displayCommit.SHA]
+		if !ok {
+			continue
+		}
+		patches := make(map[string]string)
+		for _, file := range commit.Files {
+			if file.Patch != nil {
+				patches[*file.Filename] = *file.Patch
+			}
+		}
+		for j := range displayCommit.Files {
+			file := &displayCommit.Files[j]
+			patch, ok := patches[file.Path]
+			if !ok || len(patch) > inlineDiffFileLimit() {
+				continue
+			}
+			diffLines := newDisplayDiffLines(patch)
+			size := renderedDiffSize(diffLines)
+			if size > budget {
+				continue
+			}
+			file.Diff = diffLines
+			budget -= size
+		}
+	}
+}
+
+// How many blocks the diffstat bar graph has (like on GitHub).
+const diffStatBlockCount = 5
+
+// DisplayDiffStat is the number of lines added and removed by a file, commit
+// or push.
+type DisplayDiffStat struct {
+	// Only set for commits and pushes.
+	Files     int
+	Additions int
+	Deletions int
+}
+
+// Blocks returns the styles of the blocks in the bar graph, each of which is
+// one of the commit.diffstat.block.* styles.
+func (stat *DisplayDiffStat) Blocks() []string {
+	total := stat.Additions + stat.Deletions
+	colored := total
+	if colored > diffStatBlockCount {
+		colored = diffStatBlockCount
+	}
+	added, deleted := 0, 0
+	if total > 0 {
+		added = (colored*stat.Additions + total/2) / total
+		if stat.Additions > 0 && added == 0 {
+			added = 1
+		}
+		deleted = colored - added
+		if stat.Deletions > 0 && deleted == 0 && added > 1 {
+			added--
+			deleted = 1
+		}
+	}
+	blocks := make([]string, 0, diffStatBlockCount)
+	for i := 0; i < diffStatBlockCount; i++ {
+		if i < added {
+			blocks = append(blocks, "commit.diffstat.block.added")
+		} else if i < added+deleted {
+			blocks = append(blocks, "commit.diffstat.block.deleted")
+		} else {
+			blocks = append(blocks, "commit.diffstat.block.neutral")
+		}
+	}
+	return blocks
+}
+
+// addDiffStats adds line counts to the commits and their files.
+func addDiffStats(displayCommits []DisplayCommit, details map[string]*ApiCommit) {
+	for i := range displayCommits {
+		displayCommit := &displayCommits[i]
+		commit, ok := details[displayCommit.SHA]
+		if !ok {
+			continue
+		}
+		fileStats := make(map[string]*DisplayDiffStat)
+		commitStat := &DisplayDiffStat{Files: len(commit.Files)}
+		for _, file := range commit.Files {
+			if file.Additions == nil || file.Deletions == nil {
+				continue
+			}
+			fileStats[*file.Filename] = &DisplayDiffStat{
+				Additions: *file.Additions,
+				Deletions: *file.Deletions,
+			}
+			commitStat.Additions += *file.Additions
+			commitStat.Deletions += *file.Deletions
+		}
+		displayCommit.Stats = commitStat
+		for j := range displayCommit.Files {
+			file := &displayCommit.Files[j]
+			file.Stats = fileStats[file.Path]
+		}
+	}
+}
+
+// newPushDiffStat returns the totals across all of the commits (counting each
+// file once), or nil if not all of them have line counts. Only the commits
+// that are shown are counted, so for pushes with more commits than that the
+// totals are labeled as such.
+func newPushDiffStat(displayCommits []DisplayCommit, details map[string]*ApiCommit) *DisplayDiffStat {
+	if len(displayCommits) == 0 {
+		return nil
+	}
+	paths := make(map[string]bool)
+	pushStat := &DisplayDiffStat{}
+	for _, displayCommit := range displayCommits {
+		commit, ok := details[displayCommit.SHA]
+		if !ok || displayCommit.Stats == nil {
+			return nil
+		}
+		for _, file := range commit.Files {
+			paths[*file.Filename] = true
+		}
+		pushStat.Additions += displayCommit.Stats.Additions
+		pushStat.Deletions += displayCommit.Stats.Deletions
+	}
+	pushStat.Files = len(paths)
+	return pushStat
+}
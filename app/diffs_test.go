@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNewDisplayDiffLines(t *testing.T) {
+	got := newDisplayDiffLines("@@ -1,3 +1,3 @@\n package main\n-import \"fmt\"\n+import \"log\"\n")
+	want := []DisplayDiffLine{
+		{DiffLineHunk, "@@ -1,3 +1,3 @@"},
+		{DiffLineContext, " package main"},
+		{DiffLineDeletion, "-import \"fmt\""},
+		{DiffLineAddition, "+import \"log\""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// The patches in testdata/api/commit.json.
+const (
+	testMainPatch = "@@ -1,3 +1,4 @@\n package main\n-import \"fmt\"\n+import (\n+\t\"fmt\"\n )"
+	testNewPatch  = "@@ -0,0 +1,400 @@\n+BIGBIGBIG\n+" + "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"
+)
+
+func TestRenderedDiffSize(t *testing.T) {
+	line := DisplayDiffLine{DiffLineDeletion, "-import \"fmt\""}
+	want := diffLineMarkupSize + len(getStyle("commit.diff.line")) +
+		len(getStyle("commit.diff.line.deletion")) + len("-import &#34;fmt&#34;")
+	if got := renderedDiffSize([]DisplayDiffLine{line}); got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	// The styles make even small patches several times bigger.
+	if size := renderedDiffSize(newDisplayDiffLines(testMainPatch)); size < 2*len(testMainPatch) {
+		t.Errorf("got size %d for a %d byte patch", size, len(testMainPatch))
+	}
+}
+
+// testDiffCommits returns commits with the files of testdata/api/commit.json.
+func testDiffCommits(shas ...string) []DisplayCommit {
+	displayCommits := make([]DisplayCommit, 0, len(shas))
+	for _, sha := range shas {
+		displayCommits = append(displayCommits, DisplayCommit{
+			SHA: sha,
+			Files: []DisplayCommitFile{
+				{Path: "main.go", Type: CommitFileModified},
+				{Path: "new.go", Type: CommitFileAdded},
+			},
+		})
+	}
+	return displayCommits
+}
+
+func diffedFiles(displayCommits []DisplayCommit) []string {
+	var paths []string
+	for _, displayCommit := range displayCommits {
+		for _, file := range displayCommit.Files {
+			if len(file.Diff) > 0 {
+				paths = append(paths, displayCommit.SHA[:1]+"/"+file.Path)
+			}
+		}
+	}
+	return paths
+}
+
+func TestFetchCommitDetails(t *testing.T) {
+	// More commits than workers, one of which can't be fetched.
+	routes := make(map[string]string)
+	var shas []string
+	for i := 0; i < 3*commitDetailsWorkers; i++ {
+		sha := fmt.Sprintf("%040x", i)
+		shas = append(shas, sha)
+		if i != 5 {
+			routes["/repos/o/r/commits/"+sha] = "commit.json"
+		}
+	}
+	defer serveGitHubAPI(routes)()
+	repoFullName := "o/r"
+	details := fetchCommitDetails(testDiffCommits(shas...), &WebHookRepository{FullName: &repoFullName}, testContext)
+	if len(details) != len(shas)-1 {
+		t.Errorf("got details of %d commits, want %d", len(details), len(shas)-1)
+	}
+	if _, ok := details[shas[5]]; ok {
+		t.Errorf("got details of %s, which couldn't be fetched", shas[5])
+	}
+}
+
+func TestAddMissingFiles(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/commits/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": "commit.json",
+	})()
+	repoFullName := "o/r"
+	// Like the commits from a comparison, which don't have their files.
+	displayCommits := []DisplayCommit{{
+		SHA: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		URL: "https://github.com/o/r/commit/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+	}}
+	details := fetchCommitDetails(displayCommits, &WebHookRepository{FullName: &repoFullName}, testContext)
+	addMissingFiles(displayCommits, details)
+	want := []DisplayCommitFile{
+		{Path: "main.go", Type: CommitFileModified, URL: "https://github.com/o/r/commit/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa#diff-0"},
+		{Path: "new.go", Type: CommitFileAdded, URL: "https://github.com/o/r/commit/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa#diff-1"},
+	}
+	if !reflect.DeepEqual(displayCommits[0].Files, want) {
+		t.Errorf("got %+v, want %+v", displayCommits[0].Files, want)
+	}
+	// So that they get diffs too.
+	addInlineDiffs(displayCommits, details)
+	if got := diffedFiles(displayCommits); !reflect.DeepEqual(got, []string{"a/main.go", "a/new.go"}) {
+		t.Errorf("got diffs of %v", got)
+	}
+}
+
+func TestAddInlineDiffs(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/commits/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": "commit.json",
+		"/repos/o/r/commits/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": "commit.json",
+	})()
+	repoFullName := "o/r"
+	repo := &WebHookRepository{FullName: &repoFullName}
+	shas := []string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+	mainSize := renderedDiffSize(newDisplayDiffLines(testMainPatch))
+	newSize := renderedDiffSize(newDisplayDiffLines(testNewPatch))
+	tests := []struct {
+		fileLimit  int
+		emailLimit int
+		want       []string
+	}{
+		{0, 0, []string{"a/main.go", "a/new.go", "b/main.go", "b/new.go"}},
+		// Files over the limit only link to their diff.
+		{100, 0, []string{"a/main.go", "b/main.go"}},
+		// Once the email's budget is used up, later files and commits don't
+		// get diffs.
+		{0, mainSize + newSize, []string{"a/main.go", "a/new.go"}},
+		{0, mainSize, []string{"a/main.go"}},
+		// The budget is of the rendered size, so patches that would fit
+		// by their own size may not.
+		{0, len(testMainPatch) + len(testNewPatch), nil},
+	}
+	for _, test := range tests {
+		restore := withHookConfig(HookConfig{
+			InlineDiffFileLimit:  test.fileLimit,
+			InlineDiffEmailLimit: test.emailLimit,
+		})
+		displayCommits := testDiffCommits(shas...)
+		addInlineDiffs(displayCommits, fetchCommitDetails(displayCommits, repo, testContext))
+		restore()
+		if got := diffedFiles(displayCommits); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("limits %d and %d: got %v, want %v", test.fileLimit, test.emailLimit, got, test.want)
+		}
+	}
+}
+
+func TestDiffStatBlocks(t *testing.T) {
+	const (
+		added   = "commit.diffstat.block.added"
+		deleted = "commit.diffstat.block.deleted"
+		neutral = "commit.diffstat.block.neutral"
+	)
+	tests := []struct {
+		additions int
+		deletions int
+		want      []string
+	}{
+		{0, 0, []string{neutral, neutral, neutral, neutral, neutral}},
+		{10, 0, []string{added, added, added, added, added}},
+		// Small changes only color as many blocks as lines.
+		{1, 0, []string{added, neutral, neutral, neutral, neutral}},
+		{1, 1, []string{added, deleted, neutral, neutral, neutral}},
+		// Any additions or deletions get at least one block.
+		{1, 20, []string{added, deleted, deleted, deleted, deleted}},
+		{20, 1, []string{added, added, added, added, deleted}},
+		{6, 4, []string{added, added, added, deleted, deleted}},
+	}
+	for _, test := range tests {
+		stat := &DisplayDiffStat{Additions: test.additions, Deletions: test.deletions}
+		if got := stat.Blocks(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("+%d -%d: got %v, want %v", test.additions, test.deletions, got, test.want)
+		}
+	}
+}
+
+func TestNewPushDiffStat(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/commits/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": "commit.json",
+		"/repos/o/r/commits/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": "commit.json",
+	})()
+	repoFullName := "o/r"
+	repo := &WebHookRepository{FullName: &repoFullName}
+	displayCommits := testDiffCommits("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	details := fetchCommitDetails(displayCommits, repo, testContext)
+	addDiffStats(displayCommits, details)
+	if stat := displayCommits[0].Stats; stat == nil || *stat != (DisplayDiffStat{2, 402, 1}) {
+		t.Errorf("got commit stats %+v", stat)
+	}
+	if stat := displayCommits[0].Files[1].Stats; stat == nil || *stat != (DisplayDiffStat{0, 400, 0}) {
+		t.Errorf("got file stats %+v", stat)
+	}
+	// Lines are summed across commits, but files changed by both are counted
+	// once.
+	if stat := newPushDiffStat(displayCommits, details); stat == nil || *stat != (DisplayDiffStat{2, 804, 2}) {
+		t.Errorf("got push stats %+v", stat)
+	}
+
+	// Partial totals would be misleading.
+	delete(details, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	displayCommits[1].Stats = nil
+	if stat := newPushDiffStat(displayCommits, details); stat != nil {
+		t.Errorf("got push stats %+v without all commits' details", stat)
+	}
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"html"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/github"
@@ -13,7 +13,6 @@ import (
 	"golang.org/x/net/context"
 
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
 )
 
 func safeFormattedDate(date string) string {
@@ -35,6 +34,22 @@ func safeFormattedDate(date string) string {
 	return buffer.String()
 }
 
+// formatFileSize returns a human-readable version of a size in bytes (e.g.
+// "1.2 MB").
+func formatFileSize(size int) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	value := float64(size) / 1024
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		if value < 1024 || unit == "GB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+		value /= 1024
+	}
+	return ""
+}
+
 type DisplayCommitFileType int
 
 const (
@@ -74,6 +89,10 @@ type DisplayCommitFile struct {
 	Path string
 	Type DisplayCommitFileType
 	URL  string
+	// Only set if inline diffs are enabled.
+	Diff []DisplayDiffLine
+	// Only set if the commit's details were fetched.
+	Stats *DisplayDiffStat
 }
 
 type DisplayCommitFileByPath []DisplayCommitFile
@@ -93,10 +112,44 @@ type DisplayCommit struct {
 	ShortSHA    string
 	URL         string
 	Title       string
+	Message     string
 	MessageHTML string
 	Date        time.Time
 	Commiter    DisplayCommiter
 	Files       []DisplayCommitFile
+	// Only set if the commit's details were fetched.
+	Stats *DisplayDiffStat
+	// Set for merge commits of pull requests.
+	MergedPullRequest *DisplayMergedPullRequest
+}
+
+// DisplayCommitSummary is the one-line version of a commit, for when only a
+// mention of it is needed.
+type DisplayCommitSummary struct {
+	SHA      string
+	ShortSHA string
+	URL      string
+	Title    string
+	Author   string
+}
+
+func newDisplayCommitSummary(commit *WebHookCommit) DisplayCommitSummary {
+	title, _ := getTitleAndMessageFromCommitMessage(*commit.Message)
+	author := ""
+	if commit.Author != nil {
+		if commit.Author.Username != nil && len(*commit.Author.Username) > 0 {
+			author = *commit.Author.Username
+		} else if commit.Author.Name != nil {
+			author = *commit.Author.Name
+		}
+	}
+	return DisplayCommitSummary{
+		SHA:      *commit.ID,
+		ShortSHA: (*commit.ID)[:7],
+		URL:      *commit.URL,
+		Title:    title,
+		Author:   author,
+	}
 }
 
 const (
@@ -124,70 +177,104 @@ func getTitleAndMessageFromCommitMessage(message string) (string, string) {
 	return title, message
 }
 
-func renderMessageMarkdown(message string, repo *WebHookRepository, c context.Context) string {
-	// The Markdown endpoint does not escape <, >, etc. so we need to do it
-	// ourselves.
-	messageHtml := html.EscapeString(message)
-	client := github.NewClient(urlfetch.Client(c))
-	messageHtmlRendered, _, err := client.Markdown(messageHtml, &github.MarkdownOptions{
-		Mode:    "gfm",
-		Context: *repo.FullName,
-	})
-	if err != nil {
-		log.Warningf(c, "Could not do markdown rendering, got error %s", err)
-		messageHtml = fmt.Sprintf("<div style=\"%s\">%s</div>",
-			getStyle("commit.message.block"), messageHtml)
-	} else {
-		// Use our link style
-		messageHtmlRendered = strings.Replace(
-			messageHtmlRendered,
-			"<a ",
-			fmt.Sprintf("<a style=\"%s\" ", getStyle("link")),
-			-1)
-		// Respect whitespace within blocks...
-		messageHtmlRendered = strings.Replace(
-			messageHtmlRendered,
-			"<p>",
-			fmt.Sprintf("<p style=\"%s\">", getStyle("commit.message.block")),
-			-1)
-		messageHtmlRendered = strings.Replace(
-			messageHtmlRendered,
-			"<li>",
-			fmt.Sprintf("<li style=\"%s\">", getStyle("commit.message.block")),
-			-1)
-		// ...but avoid doubling of newlines.
-		messageHtmlRendered = strings.Replace(
-			messageHtmlRendered,
-			"<br>\n",
-			"<br>",
-			-1)
-		messageHtml = messageHtmlRendered
-	}
-	return messageHtml
+func newDisplayCommit(commit *WebHookCommit, sender *github.User, repo *WebHookRepository, location *time.Location, c context.Context) DisplayCommit {
+	displayCommit := newUnrenderedDisplayCommit(commit, sender, location)
+	if len(displayCommit.Message) > 0 {
+		displayCommit.MessageHTML = renderMessageMarkdown(displayCommit.Message, repo, c)
+	}
+	return displayCommit
 }
 
-func newDisplayCommit(commit *WebHookCommit, sender *github.User, repo *WebHookRepository, location *time.Location, c context.Context) DisplayCommit {
-	title, message := getTitleAndMessageFromCommitMessage(*commit.Message)
-	messageHtml := ""
-	if len(message) > 0 {
-		messageHtml = renderMessageMarkdown(message, repo, c)
+const displayCommitWorkers = 8
+
+// Commits whose messages haven't been rendered by then are shown with their
+// plain text messages instead. Variables so that tests can use a short
+// deadline and a slow renderer.
+var displayCommitRenderDeadline = 20 * time.Second
+var renderDisplayCommitMessage = renderMessageMarkdown
+
+// newDisplayCommits is the equivalent of calling newDisplayCommit for each
+// commit, but renders their messages concurrently, since that may require API
+// requests.
+func newDisplayCommits(commits []WebHookCommit, sender *github.User, repo *WebHookRepository, location *time.Location, c context.Context) []DisplayCommit {
+	displayCommits := make([]DisplayCommit, len(commits))
+	var pending []int
+	for i := range commits {
+		displayCommits[i] = newUnrenderedDisplayCommit(&commits[i], sender, location)
+		if len(displayCommits[i].Message) > 0 {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return displayCommits
 	}
 
-	files := make([]DisplayCommitFile, 0)
-	for _, path := range commit.Added {
-		files = append(files, DisplayCommitFile{Path: path, Type: CommitFileAdded})
+	renderContext, cancel := context.WithTimeout(c, displayCommitRenderDeadline)
+	defer cancel()
+	indexes := make(chan int, len(pending))
+	for _, i := range pending {
+		indexes <- i
 	}
-	for _, path := range commit.Removed {
-		files = append(files, DisplayCommitFile{Path: path, Type: CommitFileRemoved})
+	close(indexes)
+	// Results that are finished after the deadline are discarded (the
+	// renderer skips caching them too, since the context is cancelled).
+	var mutex sync.Mutex
+	messageHtmls := make(map[int]string, len(pending))
+	var waitGroup sync.WaitGroup
+	workerCount := displayCommitWorkers
+	if workerCount > len(pending) {
+		workerCount = len(pending)
 	}
-	for _, path := range commit.Modified {
-		files = append(files, DisplayCommitFile{Path: path, Type: CommitFileModified})
+	for w := 0; w < workerCount; w++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for i := range indexes {
+				if renderContext.Err() != nil {
+					return
+				}
+				messageHtml := renderDisplayCommitMessage(displayCommits[i].Message, repo, renderContext)
+				mutex.Lock()
+				if renderContext.Err() == nil {
+					messageHtmls[i] = messageHtml
+				}
+				mutex.Unlock()
+			}
+		}()
 	}
-	sort.Sort(DisplayCommitFileByPath(files))
-	for i := range files {
-		files[i].URL = fmt.Sprintf("%s#diff-%d", *commit.URL, i)
+	done := make(chan struct{})
+	go func() {
+		waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-renderContext.Done():
+		// Workers stop at their next message (and API requests are aborted),
+		// wait for them so that none outlive the request.
+		<-done
 	}
 
+	for _, i := range pending {
+		if messageHtml, ok := messageHtmls[i]; ok {
+			displayCommits[i].MessageHTML = messageHtml
+		} else {
+			displayCommits[i].MessageHTML = renderMessagePlain(displayCommits[i].Message)
+		}
+	}
+	if len(messageHtmls) < len(pending) {
+		log.Warningf(c, "Rendered %d of %d commit messages before the deadline, showing the rest as plain text",
+			len(messageHtmls), len(pending))
+	}
+	return displayCommits
+}
+
+// newUnrenderedDisplayCommit returns the DisplayCommit for the commit, without
+// its MessageHTML.
+func newUnrenderedDisplayCommit(commit *WebHookCommit, sender *github.User, location *time.Location) DisplayCommit {
+	title, message := getTitleAndMessageFromCommitMessage(*commit.Message)
+	files := newDisplayCommitFiles(commit)
+
 	commiter := DisplayCommiter{
 		Login:     *commit.Author.Username,
 		Name:      *commit.Author.Name,
@@ -198,15 +285,35 @@ func newDisplayCommit(commit *WebHookCommit, sender *github.User, repo *WebHookR
 	}
 
 	return DisplayCommit{
-		SHA:         *commit.ID,
-		ShortSHA:    (*commit.ID)[:7],
-		URL:         *commit.URL,
-		Title:       title,
-		MessageHTML: messageHtml,
-		Date:        commit.Timestamp.In(location),
-		Commiter:    commiter,
-		Files:       files,
+		SHA:      *commit.ID,
+		ShortSHA: (*commit.ID)[:7],
+		URL:      *commit.URL,
+		Title:    title,
+		Message:  message,
+		Date:     commit.Timestamp.In(location),
+		Commiter: commiter,
+		Files:    files,
+	}
+}
+
+// newDisplayCommitFiles returns the commit's files, sorted by path (which is
+// also the order of the diffs that they link to).
+func newDisplayCommitFiles(commit *WebHookCommit) []DisplayCommitFile {
+	files := make([]DisplayCommitFile, 0)
+	for _, path := range commit.Added {
+		files = append(files, DisplayCommitFile{Path: path, Type: CommitFileAdded})
+	}
+	for _, path := range commit.Removed {
+		files = append(files, DisplayCommitFile{Path: path, Type: CommitFileRemoved})
+	}
+	for _, path := range commit.Modified {
+		files = append(files, DisplayCommitFile{Path: path, Type: CommitFileModified})
+	}
+	sort.Sort(DisplayCommitFileByPath(files))
+	for i := range files {
+		files[i].URL = fmt.Sprintf("%s#diff-%d", *commit.URL, i)
 	}
+	return files
 }
 
 func (commit DisplayCommit) DisplayDate() string {
@@ -216,3 +323,33 @@ func (commit DisplayCommit) DisplayDate() string {
 func (commit DisplayCommit) DisplayDateTooltip() string {
 	return commit.Date.Format(DisplayDateFullFormat)
 }
+
+// Comparisons (e.g. between releases) may include many commits, only this many
+// are rendered in full.
+const comparisonCommitLimit = 50
+
+// newDisplayCommitsForComparison returns the commits between base and head
+// (at most comparisonCommitLimit of them, the most recent ones) and how many
+// more there were.
+func newDisplayCommitsForComparison(repo *WebHookRepository, base string, head string, sender *github.User, location *time.Location, c context.Context) ([]DisplayCommit, int, error) {
+	comparison, err := fetchComparison(repo, base, head, c)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The comparison lists commits oldest first, the newest ones are the most
+	// interesting.
+	commits := comparison.Commits
+	if len(commits) > comparisonCommitLimit {
+		commits = commits[len(commits)-comparisonCommitLimit:]
+	}
+	// The API lists at most 250 commits, but counts all of them.
+	moreCommitCount := len(comparison.Commits) - len(commits)
+	if comparison.TotalCommits != nil {
+		moreCommitCount = *comparison.TotalCommits - len(commits)
+	}
+	webHookCommits := make([]WebHookCommit, 0, len(commits))
+	for i := range commits {
+		webHookCommits = append(webHookCommits, commits[i].WebHookCommit())
+	}
+	return newDisplayCommits(webHookCommits, sender, repo, location, c), moreCommitCount, nil
+}
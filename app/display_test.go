@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+
+	"golang.org/x/net/context"
+)
+
+func testCommits(messages ...string) []WebHookCommit {
+	timestamp := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	commits := make([]WebHookCommit, len(messages))
+	name, login := "Alice", "alice"
+	for i := range messages {
+		sha := fmt.Sprintf("%040x", i+1)
+		url := "https://github.com/o/r/commit/" + sha
+		commits[i] = WebHookCommit{
+			ID:        &sha,
+			URL:       &url,
+			Message:   &messages[i],
+			Timestamp: &timestamp,
+			Author:    &github.WebHookAuthor{Name: &name, Username: &login},
+		}
+	}
+	return commits
+}
+
+// withCommitRenderer replaces how newDisplayCommits renders messages, and its
+// deadline, returning a function that restores them.
+func withCommitRenderer(render func(string, *WebHookRepository, context.Context) string, deadline time.Duration) func() {
+	savedRender, savedDeadline := renderDisplayCommitMessage, displayCommitRenderDeadline
+	renderDisplayCommitMessage, displayCommitRenderDeadline = render, deadline
+	return func() {
+		renderDisplayCommitMessage, displayCommitRenderDeadline = savedRender, savedDeadline
+	}
+}
+
+func TestNewDisplayCommitsOrder(t *testing.T) {
+	// Earlier messages take longer, so they finish out of order.
+	defer withCommitRenderer(func(message string, repo *WebHookRepository, c context.Context) string {
+		var i int
+		message = strings.TrimSpace(message)
+		if _, err := fmt.Sscanf(message, "Body %d", &i); err != nil {
+			t.Errorf("%q: %s", message, err)
+		}
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		return "<p>" + message + "</p>"
+	}, time.Minute)()
+	var messages []string
+	for i := 0; i < 20; i++ {
+		if i%5 == 0 {
+			// Commits without a message body aren't rendered.
+			messages = append(messages, fmt.Sprintf("Title %d", i))
+		} else {
+			messages = append(messages, fmt.Sprintf("Title %d\n\nBody %d", i, i))
+		}
+	}
+	repoFullName := "o/r"
+	displayCommits := newDisplayCommits(testCommits(messages...), &github.User{},
+		&WebHookRepository{FullName: &repoFullName}, time.UTC, testContext)
+	if len(displayCommits) != len(messages) {
+		t.Fatalf("got %d commits, want %d", len(displayCommits), len(messages))
+	}
+	for i, displayCommit := range displayCommits {
+		want := ""
+		if i%5 != 0 {
+			want = fmt.Sprintf("<p>Body %d</p>", i)
+		}
+		if displayCommit.Title != fmt.Sprintf("Title %d", i) || displayCommit.MessageHTML != want {
+			t.Errorf("%d: got %q with %q, want %q", i, displayCommit.Title, displayCommit.MessageHTML, want)
+		}
+	}
+}
+
+func TestNewDisplayCommitsDeadline(t *testing.T) {
+	var running int32
+	defer withCommitRenderer(func(message string, repo *WebHookRepository, c context.Context) string {
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		message = strings.TrimSpace(message)
+		if strings.Contains(message, "slow") {
+			// Like an API request, only stops when the context is done.
+			<-c.Done()
+		}
+		return "<p>" + message + "</p>"
+	}, 50*time.Millisecond)()
+	repoFullName := "o/r"
+	start := time.Now()
+	displayCommits := newDisplayCommits(testCommits("Fast\n\nfast body", "Slow\n\nslow body", "Fast\n\nanother"), &github.User{},
+		&WebHookRepository{FullName: &repoFullName}, time.UTC, testContext)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, want about the deadline", elapsed)
+	}
+	// Workers are stopped before returning.
+	if n := atomic.LoadInt32(&running); n != 0 {
+		t.Errorf("%d renders still running", n)
+	}
+	want := []string{"<p>fast body</p>", renderMessagePlain(displayCommits[1].Message), "<p>another</p>"}
+	for i, displayCommit := range displayCommits {
+		if displayCommit.MessageHTML != want[i] {
+			t.Errorf("%d: got %q, want %q", i, displayCommit.MessageHTML, want[i])
+		}
+	}
+}
+
+func TestRenderMessageMarkdownCancelled(t *testing.T) {
+	// Renders that finish after the deadline aren't cached.
+	defer withHookConfig(HookConfig{MarkdownRenderer: MarkdownRendererLocal})()
+	repoFullName := "o/r"
+	repo := &WebHookRepository{FullName: &repoFullName}
+	c, cancel := context.WithCancel(testContext)
+	cancel()
+	message := "Rendered after the deadline"
+	if html := renderMessageMarkdown(message, repo, c); !strings.Contains(html, message) {
+		t.Errorf("got %q", html)
+	}
+	if _, ok := markdownMemory.get(markdownCacheKey(message, repo)); ok {
+		t.Error("render with a cancelled context was cached")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/urlfetch"
+)
+
+// A variable so that tests can point it at a local server.
+var gitHubAPIURL = "https://api.github.com"
+
+// gitHubTransport adds the configured token (if any) to GitHub API requests,
+// so that private repositories can be accessed and rate limits are higher.
+type gitHubTransport struct {
+	base http.RoundTripper
+}
+
+func (t *gitHubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if len(hookConfig.GitHubToken) > 0 {
+		// RoundTrippers should not modify the request they're given.
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Header = make(http.Header, len(r.Header))
+		for k, v := range r.Header {
+			r2.Header[k] = v
+		}
+		r2.Header.Set("Authorization", "token "+hookConfig.GitHubToken)
+		r = r2
+	}
+	return t.base.RoundTrip(r)
+}
+
+func gitHubClient(c context.Context) *http.Client {
+	client := urlfetch.Client(c)
+	client.Transport = &gitHubTransport{base: client.Transport}
+	return client
+}
+
+func hasGitHubToken() bool {
+	return len(hookConfig.GitHubToken) > 0
+}
+
+// fetchGitHubAPI makes a GET request to the GitHub API (path is relative to
+// https://api.github.com) and decodes the JSON response into result.
+func fetchGitHubAPI(path string, result interface{}, c context.Context) error {
+	req, err := http.NewRequest("GET", gitHubAPIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := gitHubClient(c).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GitHub API request for %s failed with %s: %s", path, resp.Status, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// The pull request commits endpoint returns at most this many commits, 100 at
+// a time.
+const (
+	maxPullRequestCommits     = 250
+	pullRequestCommitsPerPage = 100
+)
+
+// fetchPullRequestCommits returns the commits of a pull request (GitHub
+// returns at most 250).
+func fetchPullRequestCommits(repo *WebHookRepository, number int, c context.Context) ([]ApiCommit, error) {
+	var commits []ApiCommit
+	for page := 1; len(commits) < maxPullRequestCommits; page++ {
+		var pageCommits []ApiCommit
+		path := fmt.Sprintf("/repos/%s/pulls/%d/commits?per_page=%d&page=%d",
+			*repo.FullName, number, pullRequestCommitsPerPage, page)
+		if err := fetchGitHubAPI(path, &pageCommits, c); err != nil {
+			return nil, err
+		}
+		commits = append(commits, pageCommits...)
+		if len(pageCommits) < pullRequestCommitsPerPage {
+			break
+		}
+	}
+	if len(commits) > maxPullRequestCommits {
+		commits = commits[:maxPullRequestCommits]
+	}
+	return commits, nil
+}
+
+// fetchComparison returns the commits that are reachable from head but not
+// from base (GitHub returns at most 250).
+func fetchComparison(repo *WebHookRepository, base string, head string, c context.Context) (*ApiComparison, error) {
+	var comparison ApiComparison
+	path := fmt.Sprintf("/repos/%s/compare/%s...%s", *repo.FullName, base, head)
+	err := fetchGitHubAPI(path, &comparison, c)
+	if err != nil {
+		return nil, err
+	}
+	return &comparison, nil
+}
+
+// fetchReleases returns the most recent releases of a repository (only the
+// first 100).
+func fetchReleases(repo *WebHookRepository, c context.Context) ([]WebHookRelease, error) {
+	var releases []WebHookRelease
+	path := fmt.Sprintf("/repos/%s/releases?per_page=100", *repo.FullName)
+	err := fetchGitHubAPI(path, &releases, c)
+	return releases, err
+}
+
+// fetchDeployments returns the most recent deployments to an environment
+// (only the first 100), newest first.
+func fetchDeployments(repo *WebHookRepository, environment string, c context.Context) ([]WebHookDeployment, error) {
+	var deployments []WebHookDeployment
+	path := fmt.Sprintf("/repos/%s/deployments?per_page=100&environment=%s",
+		*repo.FullName, url.QueryEscape(environment))
+	err := fetchGitHubAPI(path, &deployments, c)
+	return deployments, err
+}
+
+// fetchDeploymentStatuses returns the statuses of a deployment, newest first.
+func fetchDeploymentStatuses(repo *WebHookRepository, deploymentId int, c context.Context) ([]WebHookDeploymentStatus, error) {
+	var statuses []WebHookDeploymentStatus
+	path := fmt.Sprintf("/repos/%s/deployments/%d/statuses?per_page=100", *repo.FullName, deploymentId)
+	err := fetchGitHubAPI(path, &statuses, c)
+	return statuses, err
+}
+
+// fetchWorkflowRunJobs returns the jobs of a workflow run (only the first
+// 100).
+func fetchWorkflowRunJobs(repo *WebHookRepository, runId int, c context.Context) ([]ApiWorkflowJob, error) {
+	var jobs ApiWorkflowJobs
+	path := fmt.Sprintf("/repos/%s/actions/runs/%d/jobs?per_page=100", *repo.FullName, runId)
+	err := fetchGitHubAPI(path, &jobs, c)
+	return jobs.Jobs, err
+}
+
+// fetchCheckSuiteRuns returns the latest check runs of a check suite (only
+// the first 100).
+func fetchCheckSuiteRuns(repo *WebHookRepository, checkSuiteId int, c context.Context) ([]WebHookCheckRun, error) {
+	var checkRuns ApiCheckRuns
+	path := fmt.Sprintf("/repos/%s/check-suites/%d/check-runs?filter=latest&per_page=100", *repo.FullName, checkSuiteId)
+	err := fetchGitHubAPI(path, &checkRuns, c)
+	return checkRuns.CheckRuns, err
+}
+
+func fetchPullRequest(repo *WebHookRepository, number int, c context.Context) (*WebHookPullRequest, error) {
+	var pullRequest WebHookPullRequest
+	path := fmt.Sprintf("/repos/%s/pulls/%d", *repo.FullName, number)
+	err := fetchGitHubAPI(path, &pullRequest, c)
+	if err != nil {
+		return nil, err
+	}
+	return &pullRequest, nil
+}
+
+// fetchCommitPullRequests returns the pull requests that a commit is part of
+// (or was merged by).
+func fetchCommitPullRequests(repo *WebHookRepository, sha string, c context.Context) ([]WebHookPullRequest, error) {
+	var pullRequests []WebHookPullRequest
+	path := fmt.Sprintf("/repos/%s/commits/%s/pulls", *repo.FullName, sha)
+	err := fetchGitHubAPI(path, &pullRequests, c)
+	return pullRequests, err
+}
+
+// fetchCommit returns a single commit, including its files' patches.
+func fetchCommit(repo *WebHookRepository, sha string, c context.Context) (*ApiCommit, error) {
+	var commit ApiCommit
+	path := fmt.Sprintf("/repos/%s/commits/%s", *repo.FullName, sha)
+	err := fetchGitHubAPI(path, &commit, c)
+	if err != nil {
+		return nil, err
+	}
+	return &commit, nil
+}
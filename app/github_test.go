@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestFetchPullRequestCommitsPages(t *testing.T) {
+	tests := []struct {
+		commitCount int
+		want        int
+		wantPages   int
+	}{
+		{30, 30, 1},
+		{130, 130, 2},
+		// Exactly a page, so the next (empty) one is needed to know that.
+		{200, 200, 3},
+		// GitHub doesn't return more than 250 commits.
+		{400, 250, 3},
+	}
+	for _, test := range tests {
+		pages := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pages++
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+			commits := make([]map[string]string, 0)
+			for i := (page - 1) * perPage; i < page*perPage && i < test.commitCount; i++ {
+				commits = append(commits, map[string]string{"sha": fmt.Sprintf("%040x", i+1)})
+			}
+			json.NewEncoder(w).Encode(commits)
+		}))
+		savedURL := gitHubAPIURL
+		gitHubAPIURL = server.URL
+		repoFullName := "o/r"
+		commits, err := fetchPullRequestCommits(&WebHookRepository{FullName: &repoFullName}, 7, testContext)
+		gitHubAPIURL = savedURL
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(commits) != test.want || pages != test.wantPages {
+			t.Errorf("%d commits: got %d in %d pages, want %d in %d", test.commitCount, len(commits), pages, test.want, test.wantPages)
+		}
+		if len(commits) > 0 && *commits[len(commits)-1].SHA != fmt.Sprintf("%040x", len(commits)) {
+			t.Errorf("%d commits: got %s last", test.commitCount, *commits[len(commits)-1].SHA)
+		}
+	}
+}
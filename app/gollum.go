@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type gollumEventHandler struct{}
+
+func init() {
+	registerEventHandler("gollum", gollumEventHandler{})
+}
+
+func (gollumEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload GollumPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleGollumPayload(payload, c)
+}
+
+type DisplayWikiPageSummary struct {
+	Title   string
+	Summary string
+}
+
+// Edits to a wiki page are threaded together.
+func wikiPageThreadKey(repo *WebHookRepository, page *WebHookWikiPage) string {
+	return fmt.Sprintf("wiki/%s/%s", *repo.FullName, *page.PageName)
+}
+
+// newWikiPageFile returns the page as a file, so that it can be displayed
+// like the files of a commit. Edited pages link to the diff of the edit.
+func newWikiPageFile(page *WebHookWikiPage) DisplayCommitFile {
+	file := DisplayCommitFile{
+		Path: *page.Title,
+		Type: CommitFileModified,
+		URL:  *page.HTML_URL,
+	}
+	if *page.Action == "created" {
+		file.Type = CommitFileAdded
+	} else if page.SHA != nil {
+		file.URL = fmt.Sprintf("%s/_compare/%s", *page.HTML_URL, *page.SHA)
+	}
+	return file
+}
+
+func handleGollumPayload(payload GollumPayload, c context.Context) (*EventResult, error) {
+	if len(payload.Pages) == 0 {
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	// Gollum events don't include when the edits were made.
+	editedDate := time.Now().In(location)
+
+	files := make([]DisplayCommitFile, 0, len(payload.Pages))
+	summaries := make([]DisplayWikiPageSummary, 0)
+	threadKeys := make([]string, 0, len(payload.Pages))
+	for i := range payload.Pages {
+		page := &payload.Pages[i]
+		files = append(files, newWikiPageFile(page))
+		if page.Summary != nil && len(*page.Summary) > 0 {
+			summaries = append(summaries, DisplayWikiPageSummary{
+				Title:   *page.Title,
+				Summary: *page.Summary,
+			})
+		}
+		threadKeys = append(threadKeys, wikiPageThreadKey(payload.Repo, page))
+	}
+
+	var data = map[string]interface{}{
+		"Payload":           payload,
+		"Sender":            payload.Sender,
+		"Repo":              payload.Repo,
+		"Files":             files,
+		"Summaries":         summaries,
+		"WikiURL":           *payload.Repo.HTMLURL + "/wiki",
+		"EditedDisplayDate": safeFormattedDate(editedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("gollum", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject string
+	if len(payload.Pages) == 1 {
+		page := payload.Pages[0]
+		subject = fmt.Sprintf("[%s] Wiki: %s %s", *payload.Repo.FullName, *page.Title, *page.Action)
+	} else {
+		subject = fmt.Sprintf("[%s] Wiki: %d pages updated", *payload.Repo.FullName, len(payload.Pages))
+	}
+
+	senderUserName := *payload.Sender.Login
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        subject,
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   threadKeys,
+		ReplyThreadKeys: threadKeys,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGollumEmail(t *testing.T) {
+	var payload GollumPayload
+	loadTestPayload(t, "gollum.json", &payload)
+	result, err := handleGollumPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] Wiki: 2 pages updated" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	keys := []string{"wiki/o/r/Deploy-Runbook", "wiki/o/r/Oncall"}
+	if !reflect.DeepEqual(result.NewThreadKeys, keys) || !reflect.DeepEqual(result.ReplyThreadKeys, keys) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	// Edited pages link to their changes, created ones to the page.
+	for _, s := range []string{
+		"https://github.com/o/r/wiki/Deploy-Runbook/_compare/cccccccccccccccccccccccccccccccccccccccc",
+		"Add rollback steps",
+		`href="https://github.com/o/r/wiki/Oncall"`,
+	} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestGollumSinglePage(t *testing.T) {
+	var payload GollumPayload
+	loadTestPayload(t, "gollum.json", &payload)
+	payload.Pages = payload.Pages[1:]
+	result, err := handleGollumPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := result.Emails[0].Subject; subject != "[o/r] Wiki: Oncall created" {
+		t.Errorf("got subject %q", subject)
+	}
+}
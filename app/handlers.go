@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// EventHandler generates emails for a GitHub event type. Handlers register
+// themselves (via registerEventHandler) for the event name that GitHub sends
+// in the X-GitHub-Event header.
+type EventHandler interface {
+	// Handle decodes the event's payload and renders the emails for it. It
+	// should not have side effects, since it is also used to preview emails;
+	// those belong in EventResult.OnDeliver.
+	Handle(payloadReader io.Reader, c context.Context) (*EventResult, error)
+}
+
+// EventResult is what an EventHandler generates for a single event.
+type EventResult struct {
+	Emails []*Email
+	// Keys of threads that the emails start (e.g. the SHAs of pushed
+	// commits), so that later emails can be replies to them.
+	NewThreadKeys []string
+	// Keys of threads that the emails are replies to. The first key that has
+	// a thread is used.
+	ReplyThreadKeys []string
+	// Invoked once the emails have been sent (but not when previewing).
+	OnDeliver func(c context.Context) error
+}
+
+var eventHandlers = make(map[string]EventHandler)
+
+func registerEventHandler(eventType string, handler EventHandler) {
+	if _, ok := eventHandlers[eventType]; ok {
+		panic(fmt.Sprintf("Handler for %s events registered twice", eventType))
+	}
+	eventHandlers[eventType] = handler
+}
+
+func registeredEventTypes() []string {
+	eventTypes := make([]string, 0, len(eventHandlers))
+	for eventType := range eventHandlers {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+	return eventTypes
+}
+
+// handlePayload runs the handler for the event type, and threads the emails
+// it generates. The result is nil if there is no handler for the event type.
+func handlePayload(eventType string, payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	handler, ok := eventHandlers[eventType]
+	if !ok {
+		return nil, nil
+	}
+	result, err := handler.Handle(payloadReader, c)
+	if err != nil {
+		return nil, err
+	}
+	threadEmails(result, c)
+	return result, nil
+}
+
+func threadEmails(result *EventResult, c context.Context) {
+	var thread *EmailThread
+	for _, key := range result.ReplyThreadKeys {
+		thread = getEmailThread(key, c)
+		if thread != nil {
+			break
+		}
+	}
+	if thread == nil {
+		return
+	}
+	for _, email := range result.Emails {
+		// We don't control the message ID, but hopefully subject-based
+		// threading will work.
+		email.Subject = "Re: " + thread.Subject
+		if len(thread.MessageID) > 0 {
+			if email.Headers == nil {
+				email.Headers = make(map[string]string)
+			}
+			email.Headers["In-Reply-To"] = thread.MessageID
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisteredEventTypes(t *testing.T) {
+	want := []string{"check_run", "check_suite", "commit_comment", "deployment", "deployment_status", "gollum", "issue_comment", "issues", "ping", "pull_request", "pull_request_review", "pull_request_review_comment", "push", "release", "status", "workflow_run"}
+	if got := registeredEventTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestUnregisteredEventType(t *testing.T) {
+	result, err := handlePayload("fork", strings.NewReader("{}"), testContext)
+	if result != nil || err != nil {
+		t.Errorf("got %v, %v", result, err)
+	}
+}
+
+func TestRegisterEventHandlerTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a second ping handler didn't panic")
+		}
+	}()
+	registerEventHandler("ping", pingEventHandler{})
+}
+
+func TestThreadEmails(t *testing.T) {
+	createThread("thread-emails-test", "[o/r] Original", "<original@example.com>", testContext)
+	result := &EventResult{
+		Emails: []*Email{
+			{Subject: "[o/r] Reply"},
+			{Subject: "[o/r] Another reply", Headers: map[string]string{"X-Test": "1"}},
+		},
+		// The first key that has a thread is used.
+		ReplyThreadKeys: []string{"thread-emails-test-missing", "thread-emails-test"},
+	}
+	threadEmails(result, testContext)
+	for _, email := range result.Emails {
+		if email.Subject != "Re: [o/r] Original" || email.Headers["In-Reply-To"] != "<original@example.com>" {
+			t.Errorf("got %q with headers %v", email.Subject, email.Headers)
+		}
+	}
+	if result.Emails[1].Headers["X-Test"] != "1" {
+		t.Errorf("existing headers were dropped: %v", result.Emails[1].Headers)
+	}
+
+	// Without a thread, emails are left alone.
+	result = &EventResult{
+		Emails:          []*Email{{Subject: "[o/r] New"}},
+		ReplyThreadKeys: []string{"thread-emails-test-missing"},
+	}
+	threadEmails(result, testContext)
+	if result.Emails[0].Subject != "[o/r] New" || result.Emails[0].Headers != nil {
+		t.Errorf("got %q with headers %v", result.Emails[0].Subject, result.Emails[0].Headers)
+	}
+}
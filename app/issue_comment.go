@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type issueCommentEventHandler struct{}
+
+func init() {
+	registerEventHandler("issue_comment", issueCommentEventHandler{})
+}
+
+func (issueCommentEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload IssueCommentPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleIssueCommentPayload(payload, c)
+}
+
+func handleIssueCommentPayload(payload IssueCommentPayload, c context.Context) (*EventResult, error) {
+	issue := payload.Issue
+	comment := payload.Comment
+	if *payload.Action != "created" {
+		log.Infof(c, "Ignoring issue comment %s action", *payload.Action)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	updatedDate := comment.UpdatedAt.In(location)
+
+	body := *comment.Body
+	if len(body) > 0 {
+		body = renderMessageMarkdown(body, payload.Repo, c)
+	}
+
+	var data = map[string]interface{}{
+		"Payload":            payload,
+		"Issue":              issue,
+		"Comment":            comment,
+		"Sender":             payload.Sender,
+		"Repo":               payload.Repo,
+		"Body":               body,
+		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("issue-comment", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	// Comments on pull requests are also sent as issue comments, they belong
+	// in the pull request's thread.
+	threadKey := issueThreadKey(payload.Repo, *issue.Number)
+	if issue.PullRequest != nil {
+		threadKey = pullRequestThreadKey(payload.Repo, *issue.Number)
+	}
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        issueSubject(payload.Repo, issue),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
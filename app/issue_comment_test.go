@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIssueCommentThreads(t *testing.T) {
+	var payload IssueCommentPayload
+	loadTestPayload(t, "issue_comment.json", &payload)
+	result, err := handleIssueCommentPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 || !strings.Contains(result.Emails[0].HTMLBody, "LGTM") {
+		t.Fatalf("got %+v", result.Emails)
+	}
+	// Comments on pull requests go in the pull request's thread.
+	if !reflect.DeepEqual(result.ReplyThreadKeys, []string{"pull/o/r/7"}) {
+		t.Errorf("got reply thread keys %v", result.ReplyThreadKeys)
+	}
+
+	payload.Issue.PullRequest = nil
+	result, err = handleIssueCommentPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.ReplyThreadKeys, []string{"issue/o/r/7"}) {
+		t.Errorf("got reply thread keys %v", result.ReplyThreadKeys)
+	}
+}
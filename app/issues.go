@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type issuesEventHandler struct{}
+
+func init() {
+	registerEventHandler("issues", issuesEventHandler{})
+}
+
+func (issuesEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload IssuesPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleIssuesPayload(payload, c)
+}
+
+// All emails about an issue (including comments) share a thread.
+func issueThreadKey(repo *WebHookRepository, number int) string {
+	return fmt.Sprintf("issue/%s/%d", *repo.FullName, number)
+}
+
+func issueSubject(repo *WebHookRepository, issue *WebHookIssue) string {
+	return fmt.Sprintf("[%s] #%d: %s", *repo.FullName, *issue.Number, *issue.Title)
+}
+
+type DisplayLabel struct {
+	Name string
+	// Colors are hex values (without the leading #).
+	Color     string
+	TextColor string
+}
+
+func newDisplayLabel(label *WebHookLabel) DisplayLabel {
+	color := "ededed"
+	if label.Color != nil {
+		color = *label.Color
+	}
+	// Mimic GitHub's choice of dark or light text depending on the
+	// brightness of the label.
+	textColor := "000000"
+	if rgb, err := strconv.ParseUint(color, 16, 32); err == nil {
+		r, g, b := (rgb>>16)&0xff, (rgb>>8)&0xff, rgb&0xff
+		if r*299+g*587+b*114 < 128000 {
+			textColor = "ffffff"
+		}
+	}
+	return DisplayLabel{Name: *label.Name, Color: color, TextColor: textColor}
+}
+
+// Returns how an action is described in emails and the style to use for it
+// (one of the pull.state.* styles), or an empty description if the action is
+// not one that we send emails for.
+func issueActionDisplay(action string) (description string, state string) {
+	switch action {
+	case "opened":
+		return "opened", "open"
+	case "reopened":
+		return "reopened", "open"
+	case "closed":
+		return "closed", "closed"
+	case "labeled":
+		return "labeled", "edited"
+	case "assigned":
+		return "assigned", "edited"
+	}
+	return "", ""
+}
+
+func handleIssuesPayload(payload IssuesPayload, c context.Context) (*EventResult, error) {
+	issue := payload.Issue
+	actionDescription, actionState := issueActionDisplay(*payload.Action)
+	if len(actionDescription) == 0 {
+		log.Infof(c, "Ignoring issue %s action", *payload.Action)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	updatedDate := issue.UpdatedAt.In(location)
+
+	// The body is only interesting when the issue is first opened, other
+	// actions just say what changed.
+	body := ""
+	if *payload.Action == "opened" && issue.Body != nil && len(*issue.Body) > 0 {
+		body = renderMessageMarkdown(*issue.Body, payload.Repo, c)
+	}
+	labels := make([]DisplayLabel, 0, len(issue.Labels))
+	for i := range issue.Labels {
+		labels = append(labels, newDisplayLabel(&issue.Labels[i]))
+	}
+	var label *DisplayLabel
+	if payload.Label != nil {
+		displayLabel := newDisplayLabel(payload.Label)
+		label = &displayLabel
+	}
+
+	var data = map[string]interface{}{
+		"Payload":            payload,
+		"Issue":              issue,
+		"Sender":             payload.Sender,
+		"Repo":               payload.Repo,
+		"ActionDescription":  actionDescription,
+		"ActionState":        "pull.state." + actionState,
+		"Label":              label,
+		"Labels":             labels,
+		"Assignee":           payload.Assignee,
+		"Body":               body,
+		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("issue", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	threadKey := issueThreadKey(payload.Repo, *issue.Number)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        issueSubject(payload.Repo, issue),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
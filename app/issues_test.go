@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIssuesEmail(t *testing.T) {
+	var payload IssuesPayload
+	loadTestPayload(t, "issues.json", &payload)
+	result, err := handleIssuesPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] #3: Broken" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	want := []string{"issue/o/r/3"}
+	if !reflect.DeepEqual(result.NewThreadKeys, want) || !reflect.DeepEqual(result.ReplyThreadKeys, want) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	// Labels are shown in their colors.
+	for _, s := range []string{"background:#d73a4a;color:#ffffff", ">bug<"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
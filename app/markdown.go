@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+// Values for HookConfig.MarkdownRenderer.
+const (
+	MarkdownRendererLocal = "local"
+	MarkdownRendererAPI   = "api"
+)
+
+func renderMessageMarkdown(message string, repo *WebHookRepository, c context.Context) string {
+	cacheKey := markdownCacheKey(message, repo)
+	if messageHtml, ok := getCachedMarkdown(cacheKey, c); ok {
+		return messageHtml
+	}
+	cacheable := true
+	if hookConfig.MarkdownRenderer == MarkdownRendererAPI {
+		messageHtml, err := renderMessageMarkdownWithAPI(message, repo, c)
+		if err == nil {
+			putCachedMarkdown(cacheKey, messageHtml, c)
+			return messageHtml
+		}
+		log.Warningf(c, "Could not do markdown rendering via the API, got error %s", err)
+		// Fall back to local rendering, but don't cache it, so that the API is
+		// tried again next time.
+		cacheable = false
+	}
+	messageHtml, err := renderMessageMarkdownLocally(message, repo)
+	if err != nil {
+		log.Warningf(c, "Could not do markdown rendering, got error %s", err)
+		return renderMessagePlain(message)
+	}
+	if cacheable {
+		putCachedMarkdown(cacheKey, messageHtml, c)
+	}
+	return messageHtml
+}
+
+// renderMessagePlain is the fallback for when a message's Markdown can't be
+// rendered.
+func renderMessagePlain(message string) string {
+	return fmt.Sprintf("<div style=\"%s\">%s</div>",
+		getStyle("commit.message.block"), html.EscapeString(message))
+}
+
+func renderMessageMarkdownWithAPI(message string, repo *WebHookRepository, c context.Context) (string, error) {
+	// The Markdown endpoint does not escape <, >, etc. so we need to do it
+	// ourselves.
+	messageHtml := html.EscapeString(message)
+	client := github.NewClient(gitHubClient(c))
+	messageHtmlRendered, _, err := client.Markdown(messageHtml, &github.MarkdownOptions{
+		Mode:    "gfm",
+		Context: *repo.FullName,
+	})
+	if err != nil {
+		return "", err
+	}
+	// Use our link style
+	messageHtmlRendered = strings.Replace(
+		messageHtmlRendered,
+		"<a ",
+		fmt.Sprintf("<a style=\"%s\" ", getStyle("link")),
+		-1)
+	// Respect whitespace within blocks...
+	messageHtmlRendered = strings.Replace(
+		messageHtmlRendered,
+		"<p>",
+		fmt.Sprintf("<p style=\"%s\">", getStyle("commit.message.block")),
+		-1)
+	messageHtmlRendered = strings.Replace(
+		messageHtmlRendered,
+		"<li>",
+		fmt.Sprintf("<li style=\"%s\">", getStyle("commit.message.block")),
+		-1)
+	// ...but avoid doubling of newlines.
+	messageHtmlRendered = strings.Replace(
+		messageHtmlRendered,
+		"<br>\n",
+		"<br>",
+		-1)
+	return messageHtmlRendered, nil
+}
+
+// Soft line breaks are left as newlines (instead of being turned into <br>s
+// like the API's gfm mode does), since blocks are styled with
+// white-space: pre-wrap.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(
+			util.Prioritized(referenceLinker{}, 100),
+			util.Prioritized(messageStyler{}, 200),
+		),
+	),
+	goldmark.WithRendererOptions(
+		renderer.WithNodeRenderers(
+			util.Prioritized(escapedHtmlRenderer{}, 100),
+		),
+	),
+)
+
+var markdownRepoKey = parser.NewContextKey()
+
+func renderMessageMarkdownLocally(message string, repo *WebHookRepository) (string, error) {
+	parserContext := parser.NewContext()
+	parserContext.Set(markdownRepoKey, repo)
+	var buffer bytes.Buffer
+	if err := markdown.Convert([]byte(message), &buffer, parser.WithContext(parserContext)); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// Matches the references that GitHub turns into links: issues and pull
+// requests (#123 or owner/repo#123), users (@login) and commit SHAs. Since we
+// don't know which SHAs exist, only runs of hex digits that include both
+// letters and digits are linked, to avoid picking up numbers and words like
+// "facade".
+var referencePattern = regexp.MustCompile(
+	`([A-Za-z0-9][\w.-]*/[\w.-]+)?#([0-9]+)\b|` +
+		`@([A-Za-z0-9](?:-?[A-Za-z0-9])*)\b|` +
+		`\b([0-9a-f]{7,40})\b`)
+var hexLetterPattern = regexp.MustCompile(`[a-f]`)
+var hexDigitPattern = regexp.MustCompile(`[0-9]`)
+
+// referenceLinker turns references in text into links, against the repository
+// in the parser context.
+type referenceLinker struct{}
+
+func (referenceLinker) Transform(document *ast.Document, reader text.Reader, pc parser.Context) {
+	repo, _ := pc.Get(markdownRepoKey).(*WebHookRepository)
+	if repo == nil {
+		return
+	}
+	source := reader.Source()
+	// Collect the text nodes first, since they're replaced as we go.
+	var textNodes []*ast.Text
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node.Kind() {
+		case ast.KindLink, ast.KindAutoLink, ast.KindImage, ast.KindCodeSpan, ast.KindRawHTML:
+			return ast.WalkSkipChildren, nil
+		case ast.KindText:
+			textNode := node.(*ast.Text)
+			if !textNode.IsRaw() {
+				textNodes = append(textNodes, textNode)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, textNode := range textNodes {
+		linkReferences(textNode, source, repo)
+	}
+}
+
+// referenceURL returns the URL that the reference match (indices as returned
+// by referencePattern.FindSubmatchIndex) points to and how much of it to use
+// as the link text, or an empty string if it shouldn't be linked.
+func referenceURL(value []byte, match []int, repo *WebHookRepository) (string, int) {
+	start := match[0]
+	// References have to start at a word boundary (and not be e.g. part of an
+	// email address or an HTML entity).
+	if start > 0 {
+		previous := value[start-1]
+		if previous == '&' || previous == '/' || previous == '.' || previous == '_' ||
+			previous == '-' || previous == '@' || previous == '#' ||
+			('a' <= previous && previous <= 'z') || ('A' <= previous && previous <= 'Z') ||
+			('0' <= previous && previous <= '9') {
+			return "", 0
+		}
+	}
+	end := match[1]
+	switch {
+	case match[4] != -1:
+		repoName := *repo.FullName
+		if match[2] != -1 {
+			repoName = string(value[match[2]:match[3]])
+		}
+		return fmt.Sprintf("https://github.com/%s/issues/%s", repoName, value[match[4]:match[5]]), end - start
+	case match[6] != -1:
+		return fmt.Sprintf("https://github.com/%s", value[match[6]:match[7]]), end - start
+	case match[8] != -1:
+		sha := value[match[8]:match[9]]
+		if !hexLetterPattern.Match(sha) || !hexDigitPattern.Match(sha) {
+			return "", 0
+		}
+		// Like GitHub, only show the abbreviated SHA.
+		linkLength := end - start
+		if linkLength > 7 {
+			linkLength = 7
+		}
+		return fmt.Sprintf("https://github.com/%s/commit/%s", *repo.FullName, sha), linkLength
+	}
+	return "", 0
+}
+
+// linkReferences splits the text node around the references that it contains,
+// replacing them with links.
+func linkReferences(textNode *ast.Text, source []byte, repo *WebHookRepository) {
+	segment := textNode.Segment
+	value := segment.Value(source)
+	matches := referencePattern.FindAllSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return
+	}
+	parent := textNode.Parent()
+	previousEnd := 0
+	linked := false
+	for _, match := range matches {
+		url, linkLength := referenceURL(value, match, repo)
+		if url == "" {
+			continue
+		}
+		linked = true
+		if match[0] > previousEnd {
+			before := ast.NewTextSegment(text.NewSegment(segment.Start+previousEnd, segment.Start+match[0]))
+			parent.InsertBefore(parent, textNode, before)
+		}
+		link := ast.NewLink()
+		link.Destination = []byte(url)
+		link.AppendChild(link, ast.NewTextSegment(
+			text.NewSegment(segment.Start+match[0], segment.Start+match[0]+linkLength)))
+		parent.InsertBefore(parent, textNode, link)
+		previousEnd = match[1]
+	}
+	if !linked {
+		return
+	}
+	// Whatever follows the last reference stays in the original node, so that
+	// it keeps its line break.
+	textNode.Segment = segment.WithStart(segment.Start + previousEnd)
+	if textNode.Segment.IsEmpty() && !textNode.SoftLineBreak() && !textNode.HardLineBreak() {
+		parent.RemoveChild(parent, textNode)
+	}
+}
+
+// messageStyler applies our styles to the rendered elements.
+type messageStyler struct{}
+
+func (messageStyler) Transform(document *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node.Kind() {
+		case ast.KindLink, ast.KindAutoLink:
+			node.SetAttributeString("style", []byte(getStyle("link")))
+		case ast.KindParagraph, ast.KindListItem:
+			// Respect whitespace within blocks.
+			node.SetAttributeString("style", []byte(getStyle("commit.message.block")))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// escapedHtmlRenderer renders HTML in messages as text (instead of dropping it,
+// which is what goldmark does when it's not allowed through).
+type escapedHtmlRenderer struct{}
+
+func (escapedHtmlRenderer) RegisterFuncs(registerer renderer.NodeRendererFuncRegisterer) {
+	registerer.Register(ast.KindRawHTML, renderEscapedRawHtml)
+	registerer.Register(ast.KindHTMLBlock, renderEscapedHtmlBlock)
+}
+
+func renderEscapedRawHtml(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		segments := node.(*ast.RawHTML).Segments
+		for i := 0; i < segments.Len(); i++ {
+			segment := segments.At(i)
+			w.Write(util.EscapeHTML(segment.Value(source)))
+		}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func renderEscapedHtmlBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	block := node.(*ast.HTMLBlock)
+	if entering {
+		var blockSource bytes.Buffer
+		lines := block.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			blockSource.Write(line.Value(source))
+		}
+		if block.HasClosure() {
+			blockSource.Write(block.ClosureLine.Value(source))
+		}
+		fmt.Fprintf(w, "<p style=\"%s\">", html.EscapeString(getStyle("commit.message.block")))
+		w.Write(util.EscapeHTML(bytes.TrimRight(blockSource.Bytes(), "\n")))
+		w.WriteString("</p>\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
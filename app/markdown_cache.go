@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// Rendered Markdown is cached in memory (per instance), keyed by a hash of
+// everything that the output depends on. Entries thus never need to be
+// invalidated, only expired. When rendering via the API, it's also cached in
+// the datastore (shared between instances); local rendering is cheaper than a
+// datastore round-trip, so it's not worth it then.
+
+const (
+	// Bump when the rendering code changes in a way that affects its output.
+	markdownCacheVersion    = 1
+	markdownMemoryCacheSize = 1000
+	markdownCacheRetention  = 30 * 24 * time.Hour
+)
+
+// RenderedMarkdown is the datastore tier of the cache, keyed by
+// markdownCacheKey.
+type RenderedMarkdown struct {
+	HTML      string `datastore:",noindex"`
+	CreatedAt time.Time
+}
+
+type MarkdownCacheStats struct {
+	MemoryHits    int64
+	DatastoreHits int64
+	Misses        int64
+}
+
+var markdownCacheCounters MarkdownCacheStats
+
+// markdownCacheStats returns the cache's hit and miss counts since this
+// instance started.
+func markdownCacheStats() MarkdownCacheStats {
+	return MarkdownCacheStats{
+		MemoryHits:    atomic.LoadInt64(&markdownCacheCounters.MemoryHits),
+		DatastoreHits: atomic.LoadInt64(&markdownCacheCounters.DatastoreHits),
+		Misses:        atomic.LoadInt64(&markdownCacheCounters.Misses),
+	}
+}
+
+func markdownCacheKey(message string, repo *WebHookRepository) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%s\x00%s\x00%s\x00",
+		markdownCacheVersion, hookConfig.MarkdownRenderer, stylesVersion, *repo.FullName)
+	hash.Write([]byte(message))
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// markdownMemoryCache is a least-recently-used cache of rendered Markdown.
+type markdownMemoryCache struct {
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type markdownMemoryCacheEntry struct {
+	key  string
+	html string
+}
+
+var markdownMemory = newMarkdownMemoryCache()
+
+func newMarkdownMemoryCache() *markdownMemoryCache {
+	return &markdownMemoryCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (cache *markdownMemoryCache) get(key string) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return "", false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*markdownMemoryCacheEntry).html, true
+}
+
+func (cache *markdownMemoryCache) put(key string, html string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*markdownMemoryCacheEntry).html = html
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&markdownMemoryCacheEntry{key: key, html: html})
+	if cache.order.Len() > markdownMemoryCacheSize {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*markdownMemoryCacheEntry).key)
+	}
+}
+
+func useMarkdownDatastoreCache() bool {
+	return hookConfig.MarkdownRenderer == MarkdownRendererAPI
+}
+
+func getCachedMarkdown(key string, c context.Context) (string, bool) {
+	if html, ok := markdownMemory.get(key); ok {
+		atomic.AddInt64(&markdownCacheCounters.MemoryHits, 1)
+		return html, true
+	}
+	if !useMarkdownDatastoreCache() || c.Err() != nil {
+		atomic.AddInt64(&markdownCacheCounters.Misses, 1)
+		return "", false
+	}
+	rendered := new(RenderedMarkdown)
+	err := datastore.Get(c, datastore.NewKey(c, "RenderedMarkdown", key, 0, nil), rendered)
+	if err == nil {
+		atomic.AddInt64(&markdownCacheCounters.DatastoreHits, 1)
+		markdownMemory.put(key, rendered.HTML)
+		return rendered.HTML, true
+	}
+	if err != datastore.ErrNoSuchEntity {
+		log.Warningf(c, "Could not read cached markdown: %s", err)
+	}
+	atomic.AddInt64(&markdownCacheCounters.Misses, 1)
+	return "", false
+}
+
+// putCachedMarkdown does nothing once the context is done, renders that
+// finish after their deadline aren't cached.
+func putCachedMarkdown(key string, html string, c context.Context) {
+	if c.Err() != nil {
+		return
+	}
+	markdownMemory.put(key, html)
+	if !useMarkdownDatastoreCache() {
+		return
+	}
+	rendered := &RenderedMarkdown{
+		HTML:      html,
+		CreatedAt: time.Now(),
+	}
+	_, err := datastore.Put(c, datastore.NewKey(c, "RenderedMarkdown", key, 0, nil), rendered)
+	if err != nil {
+		log.Warningf(c, "Could not cache markdown: %s", err)
+	}
+}
+
+func expireMarkdownCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Appengine-Cron") != "true" && !appengine.IsDevAppServer() {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	c := appengine.NewContext(r)
+	cutoff := time.Now().Add(-markdownCacheRetention)
+	keys, err := datastore.NewQuery("RenderedMarkdown").
+		Filter("CreatedAt <", cutoff).
+		KeysOnly().
+		GetAll(c, nil)
+	if err != nil {
+		log.Errorf(c, "Could not query expired markdown: %s", err)
+		http.Error(w, "Could not query expired markdown", http.StatusInternalServerError)
+		return
+	}
+	if err := deleteKeys(keys, c); err != nil {
+		log.Errorf(c, "Could not delete expired markdown: %s", err)
+		http.Error(w, "Could not delete expired markdown", http.StatusInternalServerError)
+		return
+	}
+	log.Infof(c, "Expired %d rendered markdown entries created before %s", len(keys), cutoff)
+	fmt.Fprintf(w, "Expired %d rendered markdown entries", len(keys))
+}
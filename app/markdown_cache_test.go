@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestMarkdownMemoryCacheEviction(t *testing.T) {
+	cache := newMarkdownMemoryCache()
+	for i := 0; i < markdownMemoryCacheSize; i++ {
+		cache.put(fmt.Sprintf("key%d", i), fmt.Sprintf("html%d", i))
+	}
+	// Reading the oldest entry makes it the most recently used one, so the
+	// next oldest is evicted instead.
+	if html, ok := cache.get("key0"); !ok || html != "html0" {
+		t.Fatalf("key0: got %q, %v", html, ok)
+	}
+	cache.put("new", "new html")
+	if _, ok := cache.get("key1"); ok {
+		t.Error("least recently used entry wasn't evicted")
+	}
+	for _, key := range []string{"key0", "key2", "new"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if cache.order.Len() != markdownMemoryCacheSize || len(cache.entries) != markdownMemoryCacheSize {
+		t.Errorf("got %d entries (%d in the map), want %d", cache.order.Len(), len(cache.entries), markdownMemoryCacheSize)
+	}
+
+	// Replacing an entry doesn't evict anything.
+	cache.put("new", "newer html")
+	if html, _ := cache.get("new"); html != "newer html" {
+		t.Errorf("replaced entry: got %q", html)
+	}
+	if _, ok := cache.get("key2"); !ok || cache.order.Len() != markdownMemoryCacheSize {
+		t.Error("replacing an entry evicted another")
+	}
+}
+
+func TestMarkdownCacheKey(t *testing.T) {
+	defer withHookConfig(HookConfig{MarkdownRenderer: MarkdownRendererLocal})()
+	savedStylesVersion := stylesVersion
+	defer func() { stylesVersion = savedStylesVersion }()
+	repo, otherRepo := "o/r", "o/other"
+	key := markdownCacheKey("Fixes #1", &WebHookRepository{FullName: &repo})
+	if markdownCacheKey("Fixes #1", &WebHookRepository{FullName: &repo}) != key {
+		t.Error("key isn't stable")
+	}
+
+	keys := map[string]string{"original": key}
+	keys["message"] = markdownCacheKey("Fixes #2", &WebHookRepository{FullName: &repo})
+	// References are linked relative to the repository.
+	keys["repository"] = markdownCacheKey("Fixes #1", &WebHookRepository{FullName: &otherRepo})
+	hookConfig.MarkdownRenderer = MarkdownRendererAPI
+	keys["renderer"] = markdownCacheKey("Fixes #1", &WebHookRepository{FullName: &repo})
+	hookConfig.MarkdownRenderer = MarkdownRendererLocal
+	stylesVersion = "changed"
+	keys["styles"] = markdownCacheKey("Fixes #1", &WebHookRepository{FullName: &repo})
+
+	seen := make(map[string]string)
+	for name, key := range keys {
+		if other, ok := seen[key]; ok {
+			t.Errorf("changing the %s doesn't change the key (same as %s)", name, other)
+		}
+		seen[key] = name
+	}
+}
+
+func TestMarkdownDatastoreCache(t *testing.T) {
+	defer withHookConfig(HookConfig{MarkdownRenderer: MarkdownRendererLocal})()
+	datastoreKey := func(key string) *datastore.Key {
+		return datastore.NewKey(testContext, "RenderedMarkdown", key, 0, nil)
+	}
+
+	// Locally rendered Markdown is only cached in memory.
+	putCachedMarkdown("local-cache-test", "local html", testContext)
+	if err := datastore.Get(testContext, datastoreKey("local-cache-test"), new(RenderedMarkdown)); err != datastore.ErrNoSuchEntity {
+		t.Errorf("local rendering was cached in the datastore: %v", err)
+	}
+	if html, ok := getCachedMarkdown("local-cache-test", testContext); !ok || html != "local html" {
+		t.Errorf("memory cache: got %q, %v", html, ok)
+	}
+	if _, err := datastore.Put(testContext, datastoreKey("datastore-cache-test"), &RenderedMarkdown{HTML: "shared html"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getCachedMarkdown("datastore-cache-test", testContext); ok {
+		t.Error("local rendering read from the datastore")
+	}
+
+	// Markdown rendered by the API is shared via the datastore.
+	hookConfig.MarkdownRenderer = MarkdownRendererAPI
+	if html, ok := getCachedMarkdown("datastore-cache-test", testContext); !ok || html != "shared html" {
+		t.Errorf("datastore cache: got %q, %v", html, ok)
+	}
+	putCachedMarkdown("api-cache-test", "api html", testContext)
+	rendered := new(RenderedMarkdown)
+	if err := datastore.Get(testContext, datastoreKey("api-cache-test"), rendered); err != nil || rendered.HTML != "api html" {
+		t.Errorf("API rendering wasn't cached in the datastore: %q, %v", rendered.HTML, err)
+	}
+}
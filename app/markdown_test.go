@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func renderLocallyForTest(t *testing.T, message string) string {
+	repoFullName := "o/r"
+	html, err := renderMessageMarkdownLocally(message, &WebHookRepository{FullName: &repoFullName})
+	if err != nil {
+		t.Fatalf("%q: %s", message, err)
+	}
+	return html
+}
+
+func TestReferenceLinking(t *testing.T) {
+	tests := []struct {
+		message string
+		links   []string
+	}{
+		{"Fixes #12", []string{`href="https://github.com/o/r/issues/12"`, ">#12</a>"}},
+		{"See other/repo#3.", []string{`href="https://github.com/other/repo/issues/3"`, ">other/repo#3</a>"}},
+		{"Thanks @alice-b!", []string{`href="https://github.com/alice-b"`, ">@alice-b</a>"}},
+		{"(#1, #2)", []string{"/issues/1", "/issues/2"}},
+		{"Multiple lines #1\nand #2", []string{"/issues/1\"", "/issues/2\"", "#1</a>\nand "}},
+	}
+	for _, test := range tests {
+		html := renderLocallyForTest(t, test.message)
+		for _, link := range test.links {
+			if !strings.Contains(html, link) {
+				t.Errorf("%q: %s does not contain %q", test.message, html, link)
+			}
+		}
+	}
+}
+
+func TestReferenceLinkingSkipped(t *testing.T) {
+	tests := []string{
+		// Not at a word boundary.
+		"user@example.com",
+		"a#1",
+		"https://example.com/#3",
+		"&#123;",
+		// Already links or code.
+		"[#1](https://example.com/)",
+		"`#1`",
+		"<https://example.com/#1>",
+	}
+	for _, message := range tests {
+		html := renderLocallyForTest(t, message)
+		if strings.Contains(html, "github.com") {
+			t.Errorf("%q was linked: %s", message, html)
+		}
+	}
+}
+
+func TestCommitSHALinking(t *testing.T) {
+	const sha = "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567"
+	tests := []struct {
+		message string
+		link    string
+	}{
+		{"Reverts " + sha, `href="https://github.com/o/r/commit/` + sha + `"`},
+		{"Follow-up to 0a1b2c3.", `href="https://github.com/o/r/commit/0a1b2c3"`},
+		// Only hex digit runs with both letters and digits look like SHAs.
+		{"A facade of deadbeef", ""},
+		{"Bump to 20240501", ""},
+		// Too short.
+		{"Oops, a1b2c3", ""},
+		// Part of a path or a mention.
+		{"See /blob/" + sha[:10], ""},
+		{"@" + sha[:10], `href="https://github.com/` + sha[:10] + `"`},
+		{"&#" + sha[:10], ""},
+	}
+	for _, test := range tests {
+		html := renderLocallyForTest(t, test.message)
+		if len(test.link) == 0 {
+			if strings.Contains(html, "/commit/") {
+				t.Errorf("%q was linked as a commit: %s", test.message, html)
+			}
+			continue
+		}
+		if !strings.Contains(html, test.link) {
+			t.Errorf("%q: %s does not contain %q", test.message, html, test.link)
+		}
+		// Commit links show the short SHA.
+		if strings.Contains(test.link, "/commit/") && !strings.Contains(html, ">0a1b2c3</a>") {
+			t.Errorf("%q: %s does not show the short SHA", test.message, html)
+		}
+	}
+}
+
+func TestMarkdownHTMLEscaping(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Handle <nil> values", "Handle &lt;nil&gt; values"},
+		{"Use <b>bold</b> here", "&lt;b&gt;bold&lt;/b&gt;"},
+		{"<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"<div>\nblock\n</div>", "&lt;div&gt;\nblock\n&lt;/div&gt;"},
+		{"<!-- comment -->", "&lt;!-- comment --&gt;"},
+		{"`<code>`", "<code>&lt;code&gt;</code>"},
+	}
+	for _, test := range tests {
+		html := renderLocallyForTest(t, test.message)
+		if !strings.Contains(html, test.want) {
+			t.Errorf("%q: %s does not contain %q", test.message, html, test.want)
+		}
+		if strings.Contains(html, "<script") || strings.Contains(html, "<div") || strings.Contains(html, "<b>") {
+			t.Errorf("%q: HTML was passed through: %s", test.message, html)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+// The message that GitHub uses for merge commits it creates, the pull
+// request's title follows on the next paragraph.
+var mergePullRequestPattern = regexp.MustCompile(`^Merge pull request #(\d+) from \S+`)
+
+// DisplayMergedPullRequest is the pull request that a merge commit merged,
+// along with the commits from it that were part of the push. Unless Expanded,
+// those are collapsed into a one-line summary that links to the pull
+// request's commits (mail clients don't reliably support <details>).
+type DisplayMergedPullRequest struct {
+	Number int
+	// Empty if it couldn't be determined.
+	Title      string
+	URL        string
+	CommitsURL string
+	Commits    []DisplayCommit
+	Expanded   bool
+}
+
+// findMergedPullRequest returns the pull request that the commit merged, or
+// nil if it's not a pull request merge commit. The number comes from the
+// commit message if it's the standard one, otherwise (for customized merge
+// messages) the pull requests that the commit is associated with are checked.
+func findMergedPullRequest(commit *WebHookCommit, repo *WebHookRepository, c context.Context) *WebHookPullRequest {
+	title, message := getTitleAndMessageFromCommitMessage(*commit.Message)
+	if match := mergePullRequestPattern.FindStringSubmatch(title); match != nil {
+		number, _ := strconv.Atoi(match[1])
+		pullRequestTitle, _ := getTitleAndMessageFromCommitMessage(strings.TrimSpace(message))
+		url := fmt.Sprintf("https://github.com/%s/pull/%d", *repo.FullName, number)
+		if len(pullRequestTitle) > 0 {
+			return &WebHookPullRequest{Number: &number, Title: &pullRequestTitle, HTML_URL: &url}
+		}
+		if !hasGitHubToken() {
+			// Not worth an unauthenticated API request, the title is left
+			// out instead.
+			return &WebHookPullRequest{Number: &number, HTML_URL: &url}
+		}
+		pullRequest, err := fetchPullRequest(repo, number, c)
+		if err != nil {
+			// The message still says that it's a merge, so it's shown as
+			// one, just without the title.
+			log.Warningf(c, "Could not fetch pull request %d: %s", number, err)
+			return &WebHookPullRequest{Number: &number, HTML_URL: &url}
+		}
+		return pullRequest
+	}
+	// Looking up associated pull requests requires an API request, so it's
+	// only done when a GitHub token is configured, and only for commits that
+	// look like merges.
+	if !hasGitHubToken() || !strings.HasPrefix(title, "Merge ") {
+		return nil
+	}
+	pullRequests, err := fetchCommitPullRequests(repo, *commit.ID, c)
+	if err != nil {
+		log.Warningf(c, "Could not fetch pull requests for %s: %s", *commit.ID, err)
+		return nil
+	}
+	for i := range pullRequests {
+		pullRequest := &pullRequests[i]
+		if pullRequest.MergeCommitSHA != nil && *pullRequest.MergeCommitSHA == *commit.ID {
+			return pullRequest
+		}
+	}
+	return nil
+}
+
+// collapseMergedPullRequests attributes merge commits to the pull requests
+// that they merged, and moves the commits from those pull requests under the
+// merge commit. commits and displayCommits must be parallel.
+func collapseMergedPullRequests(commits []WebHookCommit, displayCommits []DisplayCommit, repo *WebHookRepository, c context.Context) []DisplayCommit {
+	// Index of the merge commit that each collapsed commit is under.
+	mergeIndexes := make(map[int]int)
+	mergedPullRequests := make(map[int]*DisplayMergedPullRequest)
+	for i := range commits {
+		pullRequest := findMergedPullRequest(&commits[i], repo, c)
+		if pullRequest == nil {
+			continue
+		}
+		mergedPullRequest := &DisplayMergedPullRequest{
+			Number:     *pullRequest.Number,
+			URL:        *pullRequest.HTML_URL,
+			CommitsURL: *pullRequest.HTML_URL + "/commits",
+			Expanded:   hookConfig.ExpandMergedPullRequestCommits,
+		}
+		if pullRequest.Title != nil {
+			mergedPullRequest.Title = *pullRequest.Title
+		}
+		mergedPullRequests[i] = mergedPullRequest
+		// Without a token the pull request's commits are left where they
+		// are, rather than using up the unauthenticated API quota.
+		if !hasGitHubToken() {
+			continue
+		}
+		pullRequestCommits, err := fetchPullRequestCommits(repo, *pullRequest.Number, c)
+		if err != nil {
+			log.Warningf(c, "Could not fetch commits for pull request %d: %s", *pullRequest.Number, err)
+			continue
+		}
+		pullRequestShas := make(map[string]bool)
+		for _, commit := range pullRequestCommits {
+			pullRequestShas[*commit.SHA] = true
+		}
+		for j := range commits {
+			if _, ok := mergeIndexes[j]; !ok && j != i && pullRequestShas[*commits[j].ID] {
+				mergeIndexes[j] = i
+			}
+		}
+	}
+	if len(mergedPullRequests) == 0 {
+		return displayCommits
+	}
+
+	for j := range displayCommits {
+		i, ok := mergeIndexes[j]
+		if !ok {
+			continue
+		}
+		if _, ok := mergedPullRequests[j]; ok {
+			// A merge commit that was itself merged stays at the top level.
+			delete(mergeIndexes, j)
+			continue
+		}
+		mergedPullRequests[i].Commits = append(mergedPullRequests[i].Commits, displayCommits[j])
+	}
+	collapsedCommits := make([]DisplayCommit, 0, len(displayCommits))
+	for i, displayCommit := range displayCommits {
+		if _, ok := mergeIndexes[i]; ok {
+			continue
+		}
+		displayCommit.MergedPullRequest = mergedPullRequests[i]
+		collapsedCommits = append(collapsedCommits, displayCommit)
+	}
+	return collapsedCommits
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindMergedPullRequest(t *testing.T) {
+	defer serveGitHubAPI(nil)()
+	var payload PushPayload
+	loadTestPayload(t, "push-merge.json", &payload)
+	if pullRequest := findMergedPullRequest(&payload.Commits[0], payload.Repo, testContext); pullRequest != nil {
+		t.Errorf("got pull request %d for a regular commit", *pullRequest.Number)
+	}
+	// The pull request's title comes from the merge commit's message.
+	pullRequest := findMergedPullRequest(&payload.Commits[2], payload.Repo, testContext)
+	if pullRequest == nil || *pullRequest.Number != 7 || *pullRequest.Title != "Add the feature" ||
+		*pullRequest.HTML_URL != "https://github.com/o/r/pull/7" {
+		t.Errorf("got %+v", pullRequest)
+	}
+
+	// Without the title in the message, it's left out (rather than making an
+	// unauthenticated API request).
+	message := "Merge pull request #7 from alice/feature"
+	payload.Commits[2].Message = &message
+	pullRequest = findMergedPullRequest(&payload.Commits[2], payload.Repo, testContext)
+	if pullRequest == nil || *pullRequest.Number != 7 || pullRequest.Title != nil ||
+		*pullRequest.HTML_URL != "https://github.com/o/r/pull/7" {
+		t.Errorf("got %+v", pullRequest)
+	}
+
+	// If it can't be fetched, it's still a merge of the pull request.
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	pullRequest = findMergedPullRequest(&payload.Commits[2], payload.Repo, testContext)
+	if pullRequest == nil || *pullRequest.Number != 7 || pullRequest.Title != nil ||
+		*pullRequest.HTML_URL != "https://github.com/o/r/pull/7" {
+		t.Errorf("got %+v after a failed fetch", pullRequest)
+	}
+}
+
+func TestPushMergedPullRequest(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/pulls/7/commits": "pull-commits-merged.json",
+	})()
+	var payload PushPayload
+	loadTestPayload(t, "push-merge.json", &payload)
+
+	// Without a token, the merge commit is attributed to the pull request
+	// (based on its message) but nothing is collapsed.
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := result.Emails[0].HTMLBody
+	if !strings.Contains(body, "Merged PR #7: Add the feature") || !strings.Contains(body, "First commit") {
+		t.Errorf("got body:\n%s", body)
+	}
+
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	result, err = handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] fffffff: Merged PR #7: Add the feature" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	// All of the commits still start threads, even though the pull
+	// request's are collapsed into a link to them.
+	if len(result.NewThreadKeys) != 3 {
+		t.Errorf("got new thread keys %v", result.NewThreadKeys)
+	}
+	for _, s := range []string{"https://github.com/o/r/pull/7/commits", "2 commits</a>"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+	if strings.Contains(email.HTMLBody, "First commit") {
+		t.Errorf("pull request commits weren't collapsed:\n%s", email.HTMLBody)
+	}
+
+	hookConfig.ExpandMergedPullRequestCommits = true
+	result, err = handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := result.Emails[0].HTMLBody; !strings.Contains(body, "First commit") || !strings.Contains(body, "Second commit") {
+		t.Errorf("pull request commits weren't expanded:\n%s", body)
+	}
+}
@@ -28,6 +28,120 @@ type CommitCommentPayload struct {
 	Sender  *github.User          `json:"sender,omitempty"`
 }
 
+type PingPayload struct {
+	Zen    *string      `json:"zen,omitempty"`
+	HookID *int         `json:"hook_id,omitempty"`
+	Hook   *WebHookHook `json:"hook,omitempty"`
+	// Only one of these is set, depending on whether the hook is for a
+	// repository or an organization.
+	Repo   *WebHookRepository   `json:"repository,omitempty"`
+	Org    *WebHookOrganization `json:"organization,omitempty"`
+	Sender *github.User         `json:"sender,omitempty"`
+}
+
+type PullRequestPayload struct {
+	Action      *string             `json:"action,omitempty"`
+	Number      *int                `json:"number,omitempty"`
+	PullRequest *WebHookPullRequest `json:"pull_request,omitempty"`
+	Repo        *WebHookRepository  `json:"repository,omitempty"`
+	Sender      *github.User        `json:"sender,omitempty"`
+}
+
+type PullRequestReviewPayload struct {
+	Action      *string                   `json:"action,omitempty"`
+	Review      *WebHookPullRequestReview `json:"review,omitempty"`
+	PullRequest *WebHookPullRequest       `json:"pull_request,omitempty"`
+	Repo        *WebHookRepository        `json:"repository,omitempty"`
+	Sender      *github.User              `json:"sender,omitempty"`
+}
+
+type PullRequestReviewCommentPayload struct {
+	Action      *string                          `json:"action,omitempty"`
+	Comment     *WebHookPullRequestReviewComment `json:"comment,omitempty"`
+	PullRequest *WebHookPullRequest              `json:"pull_request,omitempty"`
+	Repo        *WebHookRepository               `json:"repository,omitempty"`
+	Sender      *github.User                     `json:"sender,omitempty"`
+}
+
+type IssuesPayload struct {
+	Action   *string            `json:"action,omitempty"`
+	Issue    *WebHookIssue      `json:"issue,omitempty"`
+	Label    *WebHookLabel      `json:"label,omitempty"`
+	Assignee *github.User       `json:"assignee,omitempty"`
+	Repo     *WebHookRepository `json:"repository,omitempty"`
+	Sender   *github.User       `json:"sender,omitempty"`
+}
+
+type IssueCommentPayload struct {
+	Action  *string              `json:"action,omitempty"`
+	Issue   *WebHookIssue        `json:"issue,omitempty"`
+	Comment *WebHookIssueComment `json:"comment,omitempty"`
+	Repo    *WebHookRepository   `json:"repository,omitempty"`
+	Sender  *github.User         `json:"sender,omitempty"`
+}
+
+type ReleasePayload struct {
+	Action  *string            `json:"action,omitempty"`
+	Release *WebHookRelease    `json:"release,omitempty"`
+	Repo    *WebHookRepository `json:"repository,omitempty"`
+	Sender  *github.User       `json:"sender,omitempty"`
+}
+
+type StatusPayload struct {
+	ID          *int               `json:"id,omitempty"`
+	SHA         *string            `json:"sha,omitempty"`
+	State       *string            `json:"state,omitempty"`
+	Context     *string            `json:"context,omitempty"`
+	Description *string            `json:"description,omitempty"`
+	TargetURL   *string            `json:"target_url,omitempty"`
+	Repo        *WebHookRepository `json:"repository,omitempty"`
+	Sender      *github.User       `json:"sender,omitempty"`
+	UpdatedAt   *time.Time         `json:"updated_at,omitempty"`
+}
+
+type CheckRunPayload struct {
+	Action   *string            `json:"action,omitempty"`
+	CheckRun *WebHookCheckRun   `json:"check_run,omitempty"`
+	Repo     *WebHookRepository `json:"repository,omitempty"`
+	Sender   *github.User       `json:"sender,omitempty"`
+}
+
+type CheckSuitePayload struct {
+	Action     *string            `json:"action,omitempty"`
+	CheckSuite *WebHookCheckSuite `json:"check_suite,omitempty"`
+	Repo       *WebHookRepository `json:"repository,omitempty"`
+	Sender     *github.User       `json:"sender,omitempty"`
+}
+
+type DeploymentPayload struct {
+	Action     *string            `json:"action,omitempty"`
+	Deployment *WebHookDeployment `json:"deployment,omitempty"`
+	Repo       *WebHookRepository `json:"repository,omitempty"`
+	Sender     *github.User       `json:"sender,omitempty"`
+}
+
+type DeploymentStatusPayload struct {
+	Action           *string                  `json:"action,omitempty"`
+	DeploymentStatus *WebHookDeploymentStatus `json:"deployment_status,omitempty"`
+	Deployment       *WebHookDeployment       `json:"deployment,omitempty"`
+	Repo             *WebHookRepository       `json:"repository,omitempty"`
+	Sender           *github.User             `json:"sender,omitempty"`
+}
+
+type WorkflowRunPayload struct {
+	Action      *string             `json:"action,omitempty"`
+	WorkflowRun *WebHookWorkflowRun `json:"workflow_run,omitempty"`
+	Workflow    *WebHookWorkflow    `json:"workflow,omitempty"`
+	Repo        *WebHookRepository  `json:"repository,omitempty"`
+	Sender      *github.User        `json:"sender,omitempty"`
+}
+
+type GollumPayload struct {
+	Pages  []WebHookWikiPage  `json:"pages,omitempty"`
+	Repo   *WebHookRepository `json:"repository,omitempty"`
+	Sender *github.User       `json:"sender,omitempty"`
+}
+
 // WebHookCommit represents the commit variant we receive from GitHub in a
 // WebHookPayload.
 type WebHookCommit struct {
@@ -57,6 +171,265 @@ type WebHookCommitComment struct {
 	Path      *string      `json:"path,omitempty"`
 }
 
+type WebHookPullRequest struct {
+	ID             *int                      `json:"id,omitempty"`
+	Number         *int                      `json:"number,omitempty"`
+	State          *string                   `json:"state,omitempty"`
+	Title          *string                   `json:"title,omitempty"`
+	Body           *string                   `json:"body,omitempty"`
+	User           *github.User              `json:"user,omitempty"`
+	Draft          *bool                     `json:"draft,omitempty"`
+	Merged         *bool                     `json:"merged,omitempty"`
+	MergedBy       *github.User              `json:"merged_by,omitempty"`
+	MergeCommitSHA *string                   `json:"merge_commit_sha,omitempty"`
+	Commits        *int                      `json:"commits,omitempty"`
+	Additions      *int                      `json:"additions,omitempty"`
+	Deletions      *int                      `json:"deletions,omitempty"`
+	ChangedFiles   *int                      `json:"changed_files,omitempty"`
+	Head           *WebHookPullRequestBranch `json:"head,omitempty"`
+	Base           *WebHookPullRequestBranch `json:"base,omitempty"`
+	HTML_URL       *string                   `json:"html_url,omitempty"`
+	DiffURL        *string                   `json:"diff_url,omitempty"`
+	CreatedAt      *time.Time                `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time                `json:"updated_at,omitempty"`
+	ClosedAt       *time.Time                `json:"closed_at,omitempty"`
+	MergedAt       *time.Time                `json:"merged_at,omitempty"`
+}
+
+type WebHookPullRequestReview struct {
+	ID          *int         `json:"id,omitempty"`
+	User        *github.User `json:"user,omitempty"`
+	Body        *string      `json:"body,omitempty"`
+	State       *string      `json:"state,omitempty"`
+	CommitID    *string      `json:"commit_id,omitempty"`
+	HTML_URL    *string      `json:"html_url,omitempty"`
+	SubmittedAt *time.Time   `json:"submitted_at,omitempty"`
+}
+
+type WebHookPullRequestReviewComment struct {
+	ID           *int         `json:"id,omitempty"`
+	User         *github.User `json:"user,omitempty"`
+	Body         *string      `json:"body,omitempty"`
+	Path         *string      `json:"path,omitempty"`
+	Position     *int         `json:"position,omitempty"`
+	Line         *int         `json:"line,omitempty"`
+	OriginalLine *int         `json:"original_line,omitempty"`
+	DiffHunk     *string      `json:"diff_hunk,omitempty"`
+	CommitID     *string      `json:"commit_id,omitempty"`
+	InReplyToID  *int         `json:"in_reply_to_id,omitempty"`
+	HTML_URL     *string      `json:"html_url,omitempty"`
+	CreatedAt    *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time   `json:"updated_at,omitempty"`
+}
+
+type WebHookPullRequestBranch struct {
+	Label *string            `json:"label,omitempty"`
+	Ref   *string            `json:"ref,omitempty"`
+	SHA   *string            `json:"sha,omitempty"`
+	User  *github.User       `json:"user,omitempty"`
+	Repo  *WebHookRepository `json:"repo,omitempty"`
+}
+
+type WebHookIssue struct {
+	ID        *int           `json:"id,omitempty"`
+	Number    *int           `json:"number,omitempty"`
+	State     *string        `json:"state,omitempty"`
+	Title     *string        `json:"title,omitempty"`
+	Body      *string        `json:"body,omitempty"`
+	User      *github.User   `json:"user,omitempty"`
+	Labels    []WebHookLabel `json:"labels,omitempty"`
+	Assignees []*github.User `json:"assignees,omitempty"`
+	HTML_URL  *string        `json:"html_url,omitempty"`
+	CreatedAt *time.Time     `json:"created_at,omitempty"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+	ClosedAt  *time.Time     `json:"closed_at,omitempty"`
+	// Only present for issues that are pull requests.
+	PullRequest *WebHookIssuePullRequest `json:"pull_request,omitempty"`
+}
+
+type WebHookIssuePullRequest struct {
+	URL      *string `json:"url,omitempty"`
+	HTML_URL *string `json:"html_url,omitempty"`
+}
+
+type WebHookIssueComment struct {
+	ID        *int         `json:"id,omitempty"`
+	User      *github.User `json:"user,omitempty"`
+	Body      *string      `json:"body,omitempty"`
+	HTML_URL  *string      `json:"html_url,omitempty"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt *time.Time   `json:"updated_at,omitempty"`
+}
+
+type WebHookLabel struct {
+	Name  *string `json:"name,omitempty"`
+	Color *string `json:"color,omitempty"`
+}
+
+// WebHookRelease is also the representation of releases in API responses.
+type WebHookRelease struct {
+	ID              *int                  `json:"id,omitempty"`
+	TagName         *string               `json:"tag_name,omitempty"`
+	TargetCommitish *string               `json:"target_commitish,omitempty"`
+	Name            *string               `json:"name,omitempty"`
+	Body            *string               `json:"body,omitempty"`
+	Draft           *bool                 `json:"draft,omitempty"`
+	Prerelease      *bool                 `json:"prerelease,omitempty"`
+	Author          *github.User          `json:"author,omitempty"`
+	Assets          []WebHookReleaseAsset `json:"assets,omitempty"`
+	HTML_URL        *string               `json:"html_url,omitempty"`
+	CreatedAt       *time.Time            `json:"created_at,omitempty"`
+	PublishedAt     *time.Time            `json:"published_at,omitempty"`
+}
+
+type WebHookReleaseAsset struct {
+	ID                 *int    `json:"id,omitempty"`
+	Name               *string `json:"name,omitempty"`
+	Label              *string `json:"label,omitempty"`
+	ContentType        *string `json:"content_type,omitempty"`
+	Size               *int    `json:"size,omitempty"`
+	DownloadCount      *int    `json:"download_count,omitempty"`
+	BrowserDownloadURL *string `json:"browser_download_url,omitempty"`
+}
+
+type WebHookCheckRun struct {
+	ID          *int                   `json:"id,omitempty"`
+	Name        *string                `json:"name,omitempty"`
+	HeadSHA     *string                `json:"head_sha,omitempty"`
+	Status      *string                `json:"status,omitempty"`
+	Conclusion  *string                `json:"conclusion,omitempty"`
+	HTML_URL    *string                `json:"html_url,omitempty"`
+	DetailsURL  *string                `json:"details_url,omitempty"`
+	Output      *WebHookCheckRunOutput `json:"output,omitempty"`
+	App         *WebHookApp            `json:"app,omitempty"`
+	StartedAt   *time.Time             `json:"started_at,omitempty"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+}
+
+type WebHookCheckRunOutput struct {
+	Title   *string `json:"title,omitempty"`
+	Summary *string `json:"summary,omitempty"`
+	Text    *string `json:"text,omitempty"`
+}
+
+type WebHookCheckSuite struct {
+	ID         *int        `json:"id,omitempty"`
+	HeadBranch *string     `json:"head_branch,omitempty"`
+	HeadSHA    *string     `json:"head_sha,omitempty"`
+	Status     *string     `json:"status,omitempty"`
+	Conclusion *string     `json:"conclusion,omitempty"`
+	App        *WebHookApp `json:"app,omitempty"`
+	UpdatedAt  *time.Time  `json:"updated_at,omitempty"`
+}
+
+type WebHookApp struct {
+	ID   *int    `json:"id,omitempty"`
+	Slug *string `json:"slug,omitempty"`
+	Name *string `json:"name,omitempty"`
+}
+
+// WebHookDeployment is also the representation of deployments in API
+// responses.
+type WebHookDeployment struct {
+	ID          *int         `json:"id,omitempty"`
+	SHA         *string      `json:"sha,omitempty"`
+	Ref         *string      `json:"ref,omitempty"`
+	Task        *string      `json:"task,omitempty"`
+	Environment *string      `json:"environment,omitempty"`
+	Description *string      `json:"description,omitempty"`
+	Creator     *github.User `json:"creator,omitempty"`
+	CreatedAt   *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time   `json:"updated_at,omitempty"`
+}
+
+// WebHookDeploymentStatus is also the representation of deployment statuses
+// in API responses.
+type WebHookDeploymentStatus struct {
+	ID             *int         `json:"id,omitempty"`
+	State          *string      `json:"state,omitempty"`
+	Description    *string      `json:"description,omitempty"`
+	Environment    *string      `json:"environment,omitempty"`
+	TargetURL      *string      `json:"target_url,omitempty"`
+	LogURL         *string      `json:"log_url,omitempty"`
+	EnvironmentURL *string      `json:"environment_url,omitempty"`
+	Creator        *github.User `json:"creator,omitempty"`
+	CreatedAt      *time.Time   `json:"created_at,omitempty"`
+}
+
+type WebHookWorkflowRun struct {
+	ID         *int    `json:"id,omitempty"`
+	Name       *string `json:"name,omitempty"`
+	RunNumber  *int    `json:"run_number,omitempty"`
+	Event      *string `json:"event,omitempty"`
+	HeadBranch *string `json:"head_branch,omitempty"`
+	HeadSHA    *string `json:"head_sha,omitempty"`
+	Status     *string `json:"status,omitempty"`
+	Conclusion *string `json:"conclusion,omitempty"`
+	HTML_URL   *string `json:"html_url,omitempty"`
+	// Only has the ID, message, timestamp, author and committer.
+	HeadCommit *WebHookCommit `json:"head_commit,omitempty"`
+	UpdatedAt  *time.Time     `json:"updated_at,omitempty"`
+}
+
+type WebHookWorkflow struct {
+	ID   *int    `json:"id,omitempty"`
+	Name *string `json:"name,omitempty"`
+	Path *string `json:"path,omitempty"`
+}
+
+type ApiCheckRuns struct {
+	TotalCount *int              `json:"total_count,omitempty"`
+	CheckRuns  []WebHookCheckRun `json:"check_runs,omitempty"`
+}
+
+type ApiWorkflowJobs struct {
+	TotalCount *int             `json:"total_count,omitempty"`
+	Jobs       []ApiWorkflowJob `json:"jobs,omitempty"`
+}
+
+type ApiWorkflowJob struct {
+	ID          *int                 `json:"id,omitempty"`
+	Name        *string              `json:"name,omitempty"`
+	Status      *string              `json:"status,omitempty"`
+	Conclusion  *string              `json:"conclusion,omitempty"`
+	HTML_URL    *string              `json:"html_url,omitempty"`
+	StartedAt   *time.Time           `json:"started_at,omitempty"`
+	CompletedAt *time.Time           `json:"completed_at,omitempty"`
+	Steps       []ApiWorkflowJobStep `json:"steps,omitempty"`
+}
+
+type ApiWorkflowJobStep struct {
+	Name       *string `json:"name,omitempty"`
+	Number     *int    `json:"number,omitempty"`
+	Conclusion *string `json:"conclusion,omitempty"`
+}
+
+type WebHookWikiPage struct {
+	PageName *string `json:"page_name,omitempty"`
+	Title    *string `json:"title,omitempty"`
+	Summary  *string `json:"summary,omitempty"`
+	// "created" or "edited".
+	Action   *string `json:"action,omitempty"`
+	SHA      *string `json:"sha,omitempty"`
+	HTML_URL *string `json:"html_url,omitempty"`
+}
+
+type WebHookHook struct {
+	ID        *int       `json:"id,omitempty"`
+	Type      *string    `json:"type,omitempty"`
+	Name      *string    `json:"name,omitempty"`
+	Active    *bool      `json:"active,omitempty"`
+	Events    []string   `json:"events,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type WebHookOrganization struct {
+	ID        *int    `json:"id,omitempty"`
+	Login     *string `json:"login,omitempty"`
+	AvatarURL *string `json:"avatar_url,omitempty"`
+}
+
 type WebHookRepository struct {
 	ID               *int               `json:"id,omitempty"`
 	Owner            *github.User       `json:"owner,omitempty"`
@@ -139,6 +512,83 @@ type WebHookRepository struct {
 
 // Represents the payload received from the /commits API call
 type ApiCommit struct {
-	Commit   *WebHookCommit `json:"commit,omitempty"`
-	HTML_URL *string        `json:"html_url,omitempty"`
+	SHA       *string         `json:"sha,omitempty"`
+	Commit    *ApiGitCommit   `json:"commit,omitempty"`
+	Author    *github.User    `json:"author,omitempty"`
+	Committer *github.User    `json:"committer,omitempty"`
+	HTML_URL  *string         `json:"html_url,omitempty"`
+	Files     []ApiCommitFile `json:"files,omitempty"`
+}
+
+// Represents the payload received from the /compare API call
+type ApiComparison struct {
+	Status       *string     `json:"status,omitempty"`
+	AheadBy      *int        `json:"ahead_by,omitempty"`
+	BehindBy     *int        `json:"behind_by,omitempty"`
+	TotalCommits *int        `json:"total_commits,omitempty"`
+	HTML_URL     *string     `json:"html_url,omitempty"`
+	Commits      []ApiCommit `json:"commits,omitempty"`
+}
+
+type ApiGitCommit struct {
+	Author    *ApiGitAuthor `json:"author,omitempty"`
+	Committer *ApiGitAuthor `json:"committer,omitempty"`
+	Message   *string       `json:"message,omitempty"`
+}
+
+type ApiGitAuthor struct {
+	Name  *string    `json:"name,omitempty"`
+	Email *string    `json:"email,omitempty"`
+	Date  *time.Time `json:"date,omitempty"`
+}
+
+type ApiCommitFile struct {
+	Filename *string `json:"filename,omitempty"`
+	Status   *string `json:"status,omitempty"`
+	// Unified diff hunks, only included when fetching a single commit (and
+	// not for binary or very large files).
+	Patch     *string `json:"patch,omitempty"`
+	Additions *int    `json:"additions,omitempty"`
+	Deletions *int    `json:"deletions,omitempty"`
+}
+
+// WebHookCommit converts the API representation of a commit to the one that
+// we get in push payloads, so that it can be displayed the same way. File
+// lists are only present in API responses for single commits.
+func (commit *ApiCommit) WebHookCommit() WebHookCommit {
+	webHookAuthor := func(user *github.User, gitAuthor *ApiGitAuthor) *github.WebHookAuthor {
+		author := &github.WebHookAuthor{
+			Name:     gitAuthor.Name,
+			Email:    gitAuthor.Email,
+			Username: new(string),
+		}
+		if user != nil && user.Login != nil {
+			author.Username = user.Login
+		}
+		if author.Name == nil {
+			author.Name = author.Username
+		}
+		return author
+	}
+	// Whether the commit is distinct is only known for the commits in push
+	// payloads, so Distinct is left unset.
+	result := WebHookCommit{
+		Author:    webHookAuthor(commit.Author, commit.Commit.Author),
+		Committer: webHookAuthor(commit.Committer, commit.Commit.Committer),
+		URL:       commit.HTML_URL,
+		ID:        commit.SHA,
+		Message:   commit.Commit.Message,
+		Timestamp: commit.Commit.Author.Date,
+	}
+	for _, file := range commit.Files {
+		switch *file.Status {
+		case "added":
+			result.Added = append(result.Added, *file.Filename)
+		case "removed":
+			result.Removed = append(result.Removed, *file.Filename)
+		default:
+			result.Modified = append(result.Modified, *file.Filename)
+		}
+	}
+	return result
 }
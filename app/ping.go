@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+type pingEventHandler struct{}
+
+func init() {
+	registerEventHandler("ping", pingEventHandler{})
+}
+
+func (pingEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload PingPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handlePingPayload(payload, c)
+}
+
+func handlePingPayload(payload PingPayload, c context.Context) (*EventResult, error) {
+	result := &EventResult{
+		OnDeliver: func(c context.Context) error {
+			return recordInstallation(payload, c)
+		},
+	}
+	if !hookConfig.SendPingEmail {
+		return result, nil
+	}
+	// Organization hooks' pings are for all of its repositories.
+	var name string
+	if payload.Repo != nil && payload.Repo.FullName != nil {
+		name = *payload.Repo.FullName
+	} else if payload.Org != nil && payload.Org.Login != nil {
+		name = *payload.Org.Login
+	} else {
+		log.Warningf(c, "Not sending email for ping with neither a repository nor an organization")
+		return result, nil
+	}
+	var data = map[string]interface{}{
+		"Payload": payload,
+		"Hook":    payload.Hook,
+		"Repo":    payload.Repo,
+		"Org":     payload.Org,
+		"Sender":  payload.Sender,
+	}
+	htmlBody, textBody, err := renderEmailBodies("ping", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := "github"
+	if payload.Sender != nil && payload.Sender.Login != nil {
+		senderUserName = *payload.Sender.Login
+	}
+	subject := fmt.Sprintf("[%s] Hook set up", name)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        subject,
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	result.Emails = []*Email{message}
+	return result, nil
+}
+
+// Installation records a repository (or organization) that the hook has been
+// set up for (based on the ping event that GitHub sends when a hook is added).
+type Installation struct {
+	RepoFullName string    `datastore:",noindex"`
+	OrgLogin     string    `datastore:",noindex"`
+	HookID       int64     `datastore:",noindex"`
+	Events       []string  `datastore:",noindex"`
+	Active       bool      `datastore:",noindex"`
+	FirstSeen    time.Time `datastore:",noindex"`
+	LastSeen     time.Time `datastore:",noindex"`
+}
+
+func recordInstallation(payload PingPayload, c context.Context) error {
+	key := installationKey(payload, c)
+	if key == nil {
+		// Retrying wouldn't help, so the ping is just dropped.
+		log.Warningf(c, "Not recording installation for ping without a repository, organization or hook ID")
+		return nil
+	}
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		installation := new(Installation)
+		err := datastore.Get(tc, key, installation)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		now := time.Now()
+		if err == datastore.ErrNoSuchEntity {
+			installation.FirstSeen = now
+		}
+		if payload.Repo != nil && payload.Repo.FullName != nil {
+			installation.RepoFullName = *payload.Repo.FullName
+		}
+		if payload.Org != nil && payload.Org.Login != nil {
+			installation.OrgLogin = *payload.Org.Login
+		}
+		installation.LastSeen = now
+		installation.Active = true
+		if payload.HookID != nil {
+			installation.HookID = int64(*payload.HookID)
+		}
+		if payload.Hook != nil {
+			installation.Events = payload.Hook.Events
+			if payload.Hook.Active != nil {
+				installation.Active = *payload.Hook.Active
+			}
+		}
+		_, err = datastore.Put(tc, key, installation)
+		if err == nil {
+			log.Infof(c, "Recorded installation: %v", installation)
+		}
+		return err
+	}, nil)
+}
+
+// installationKey returns the key of the installation that the ping is for:
+// repository hooks are keyed by the repository's full name, organization
+// hooks by the organization's login (which can't contain a slash, so they
+// don't collide), and anything else by its hook ID. Returns nil if the ping
+// has none of those.
+func installationKey(payload PingPayload, c context.Context) *datastore.Key {
+	if payload.Repo != nil && payload.Repo.FullName != nil {
+		return datastore.NewKey(c, "Installation", *payload.Repo.FullName, 0, nil)
+	}
+	if payload.Org != nil && payload.Org.Login != nil {
+		return datastore.NewKey(c, "Installation", *payload.Org.Login, 0, nil)
+	}
+	if payload.HookID != nil {
+		return datastore.NewKey(c, "Installation", "", int64(*payload.HookID), nil)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRecordInstallation(t *testing.T) {
+	var payload PingPayload
+	loadTestPayload(t, "ping.json", &payload)
+	key := datastore.NewKey(testContext, "Installation", "o/ping", 0, nil)
+
+	if err := recordInstallation(payload, testContext); err != nil {
+		t.Fatal(err)
+	}
+	first := new(Installation)
+	if err := datastore.Get(testContext, key, first); err != nil {
+		t.Fatal(err)
+	}
+	if first.RepoFullName != "o/ping" || first.HookID != 30 || !first.Active ||
+		!reflect.DeepEqual(first.Events, []string{"push", "pull_request"}) || first.FirstSeen.IsZero() {
+		t.Errorf("got %+v", first)
+	}
+
+	// Pinging again (e.g. after the hook is edited) updates the installation,
+	// but it's still first seen at the original time.
+	time.Sleep(time.Millisecond)
+	inactive := false
+	payload.Hook.Active = &inactive
+	if err := recordInstallation(payload, testContext); err != nil {
+		t.Fatal(err)
+	}
+	second := new(Installation)
+	if err := datastore.Get(testContext, key, second); err != nil {
+		t.Fatal(err)
+	}
+	if !second.FirstSeen.Equal(first.FirstSeen) || !second.LastSeen.After(first.LastSeen) || second.Active {
+		t.Errorf("got %+v after %+v", second, first)
+	}
+}
+
+func TestPingEmail(t *testing.T) {
+	var payload PingPayload
+	loadTestPayload(t, "ping.json", &payload)
+
+	// The installation is recorded even if no email is sent.
+	defer withHookConfig(HookConfig{})()
+	result, err := handlePingPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 0 || result.OnDeliver == nil {
+		t.Errorf("got %d emails, OnDeliver %v without SendPingEmail", len(result.Emails), result.OnDeliver != nil)
+	}
+
+	hookConfig.SendPingEmail = true
+	result, err = handlePingPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 || result.OnDeliver == nil {
+		t.Fatalf("got %d emails, OnDeliver %v", len(result.Emails), result.OnDeliver != nil)
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/ping] Hook set up" || email.SenderUserName != "alice" {
+		t.Errorf("got %q from %s", email.Subject, email.SenderUserName)
+	}
+	for _, want := range []string{"https://github.com/o/ping", "is now wired up", "Hook 30", ">pull_request<"} {
+		if !strings.Contains(email.HTMLBody, want) {
+			t.Errorf("body doesn't contain %q:\n%s", want, email.HTMLBody)
+		}
+	}
+}
+
+func TestOrganizationPing(t *testing.T) {
+	var payload PingPayload
+	loadTestPayload(t, "ping-org.json", &payload)
+	defer withHookConfig(HookConfig{SendPingEmail: true})()
+	result, err := handlePingPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o] Hook set up" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	for _, body := range []string{email.HTMLBody, email.TextBody} {
+		if !strings.Contains(body, "repositories are now wired up") {
+			t.Errorf("got body:\n%s", body)
+		}
+	}
+
+	// Organization hooks have no repository, so they're recorded by the
+	// organization's login.
+	if err := result.OnDeliver(testContext); err != nil {
+		t.Fatal(err)
+	}
+	installation := new(Installation)
+	if err := datastore.Get(testContext, datastore.NewKey(testContext, "Installation", "o", 0, nil), installation); err != nil {
+		t.Fatal(err)
+	}
+	if installation.OrgLogin != "o" || installation.RepoFullName != "" || installation.HookID != 31 {
+		t.Errorf("got %+v", installation)
+	}
+
+	// Failing on a ping without either would only retry it until it's
+	// dead-lettered, so it's recorded by the hook ID instead.
+	payload.Org = nil
+	payload.Sender = nil
+	result, err = handlePingPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 0 {
+		t.Errorf("got %d emails", len(result.Emails))
+	}
+	if err := result.OnDeliver(testContext); err != nil {
+		t.Fatal(err)
+	}
+	if err := datastore.Get(testContext, datastore.NewKey(testContext, "Installation", "", 31, nil), new(Installation)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type pullRequestEventHandler struct{}
+
+func init() {
+	registerEventHandler("pull_request", pullRequestEventHandler{})
+}
+
+func (pullRequestEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload PullRequestPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handlePullRequestPayload(payload, c)
+}
+
+// All emails about a pull request (including reviews and comments) share a
+// thread.
+func pullRequestThreadKey(repo *WebHookRepository, number int) string {
+	return fmt.Sprintf("pull/%s/%d", *repo.FullName, number)
+}
+
+func pullRequestSubject(repo *WebHookRepository, pullRequest *WebHookPullRequest) string {
+	return fmt.Sprintf("[%s] #%d: %s", *repo.FullName, *pullRequest.Number, *pullRequest.Title)
+}
+
+// Returns how an action is described in emails (e.g. "merged") and the style
+// to use for it (one of the pull.state.* styles), or an empty description if
+// the action is not one that we send emails for.
+func pullRequestActionDisplay(action string, pullRequest *WebHookPullRequest) (description string, state string) {
+	switch action {
+	case "opened":
+		if pullRequest.Draft != nil && *pullRequest.Draft {
+			return "opened draft", "draft"
+		}
+		return "opened", "open"
+	case "reopened":
+		return "reopened", "open"
+	case "ready_for_review":
+		return "marked as ready for review", "open"
+	case "edited":
+		return "edited", "edited"
+	case "closed":
+		if pullRequest.Merged != nil && *pullRequest.Merged {
+			return "merged", "merged"
+		}
+		return "closed", "closed"
+	}
+	return "", ""
+}
+
+func handlePullRequestPayload(payload PullRequestPayload, c context.Context) (*EventResult, error) {
+	pullRequest := payload.PullRequest
+	actionDescription, actionState := pullRequestActionDisplay(*payload.Action, pullRequest)
+	if len(actionDescription) == 0 {
+		log.Infof(c, "Ignoring pull request %s action", *payload.Action)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	updatedDate := pullRequest.UpdatedAt.In(location)
+
+	body := ""
+	if pullRequest.Body != nil && len(*pullRequest.Body) > 0 {
+		body = renderMessageMarkdown(*pullRequest.Body, payload.Repo, c)
+	}
+
+	// Listing the commits requires API requests (one per page), so it's only
+	// done when a GitHub token is configured, and only for actions that
+	// (may) change them.
+	var displayCommits []DisplayCommit
+	action := *payload.Action
+	if hasGitHubToken() && (action == "opened" || action == "synchronize" || action == "reopened") {
+		apiCommits, err := fetchPullRequestCommits(payload.Repo, *pullRequest.Number, c)
+		if err != nil {
+			// The email is still useful without the commits.
+			log.Warningf(c, "Could not fetch commits for pull request %d: %s", *pullRequest.Number, err)
+		}
+		commits := make([]WebHookCommit, 0, len(apiCommits))
+		for i := range apiCommits {
+			commits = append(commits, apiCommits[i].WebHookCommit())
+		}
+		displayCommits = newDisplayCommits(commits, payload.Sender, payload.Repo, location, c)
+	}
+
+	var data = map[string]interface{}{
+		"Payload":            payload,
+		"PullRequest":        pullRequest,
+		"Sender":             payload.Sender,
+		"Repo":               payload.Repo,
+		"ActionDescription":  actionDescription,
+		"ActionState":        "pull.state." + actionState,
+		"Body":               body,
+		"Commits":            displayCommits,
+		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("pull-request", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	threadKey := pullRequestThreadKey(payload.Repo, *pullRequest.Number)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        pullRequestSubject(payload.Repo, pullRequest),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type pullRequestReviewEventHandler struct{}
+
+func init() {
+	registerEventHandler("pull_request_review", pullRequestReviewEventHandler{})
+}
+
+func (pullRequestReviewEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload PullRequestReviewPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handlePullRequestReviewPayload(payload, c)
+}
+
+// Returns how a review state is described in emails and the style to use for
+// it (one of the pull.review.state.* styles).
+func pullRequestReviewStateDisplay(state string) (description string, style string) {
+	switch state {
+	case "approved":
+		return "approved", "approved"
+	case "changes_requested":
+		return "requested changes", "changes_requested"
+	case "dismissed":
+		return "review dismissed", "dismissed"
+	}
+	return "reviewed", "commented"
+}
+
+func handlePullRequestReviewPayload(payload PullRequestReviewPayload, c context.Context) (*EventResult, error) {
+	review := payload.Review
+	if *payload.Action != "submitted" && *payload.Action != "dismissed" {
+		log.Infof(c, "Ignoring pull request review %s action", *payload.Action)
+		return &EventResult{}, nil
+	}
+	body := ""
+	if review.Body != nil && len(*review.Body) > 0 {
+		body = renderMessageMarkdown(*review.Body, payload.Repo, c)
+	}
+	// Reviews that are just a container for line comments don't need their
+	// own email, the comments themselves are sent as review comment events.
+	if *review.State == "commented" && len(body) == 0 {
+		log.Infof(c, "Ignoring review without a body")
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	submittedDate := time.Now().In(location)
+	if review.SubmittedAt != nil {
+		submittedDate = review.SubmittedAt.In(location)
+	}
+	stateDescription, stateStyle := pullRequestReviewStateDisplay(*review.State)
+
+	var data = map[string]interface{}{
+		"Payload":              payload,
+		"Review":               review,
+		"PullRequest":          payload.PullRequest,
+		"Sender":               payload.Sender,
+		"Repo":                 payload.Repo,
+		"StateDescription":     stateDescription,
+		"StateStyle":           "pull.review.state." + stateStyle,
+		"Body":                 body,
+		"SubmittedDisplayDate": safeFormattedDate(submittedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("pull-request-review", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	threadKey := pullRequestThreadKey(payload.Repo, *payload.PullRequest.Number)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        pullRequestSubject(payload.Repo, payload.PullRequest),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type pullRequestReviewCommentEventHandler struct{}
+
+func init() {
+	registerEventHandler("pull_request_review_comment", pullRequestReviewCommentEventHandler{})
+}
+
+func (pullRequestReviewCommentEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload PullRequestReviewCommentPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handlePullRequestReviewCommentPayload(payload, c)
+}
+
+func handlePullRequestReviewCommentPayload(payload PullRequestReviewCommentPayload, c context.Context) (*EventResult, error) {
+	comment := payload.Comment
+	if *payload.Action != "created" {
+		log.Infof(c, "Ignoring pull request review comment %s action", *payload.Action)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	updatedDate := comment.UpdatedAt.In(location)
+
+	body := *comment.Body
+	if len(body) > 0 {
+		body = renderMessageMarkdown(body, payload.Repo, c)
+	}
+	// Comments on outdated diffs no longer have a line.
+	line := comment.Line
+	if line == nil {
+		line = comment.OriginalLine
+	}
+
+	var data = map[string]interface{}{
+		"Payload":            payload,
+		"Comment":            comment,
+		"PullRequest":        payload.PullRequest,
+		"Sender":             payload.Sender,
+		"Repo":               payload.Repo,
+		"Line":               line,
+		"Body":               body,
+		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("pull-request-review-comment", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	threadKey := pullRequestThreadKey(payload.Repo, *payload.PullRequest.Number)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        pullRequestSubject(payload.Repo, payload.PullRequest),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
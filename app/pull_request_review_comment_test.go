@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPullRequestReviewCommentEmail(t *testing.T) {
+	var payload PullRequestReviewCommentPayload
+	loadTestPayload(t, "pull_request_review_comment.json", &payload)
+	result, err := handlePullRequestReviewCommentPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] #7: Add thing" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	if !reflect.DeepEqual(result.ReplyThreadKeys, []string{"pull/o/r/7"}) {
+		t.Errorf("got reply thread keys %v", result.ReplyThreadKeys)
+	}
+	// The comment is shown with the diff hunk that it's on, and since the
+	// diff is outdated, with its original line.
+	for _, s := range []string{"Nit: rename", "main.go#L12", "&#43;import"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPullRequestReviewEmail(t *testing.T) {
+	var payload PullRequestReviewPayload
+	loadTestPayload(t, "pull_request_review.json", &payload)
+	result, err := handlePullRequestReviewPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	// Reviews are in the pull request's thread.
+	if email.Subject != "[o/r] #7: Add thing" || email.SenderUserName != "bob" {
+		t.Errorf("got %+v", email)
+	}
+	if !reflect.DeepEqual(result.ReplyThreadKeys, []string{"pull/o/r/7"}) {
+		t.Errorf("got reply thread keys %v", result.ReplyThreadKeys)
+	}
+	for _, s := range []string{">approved<", "https://github.com/o/r/pull/7#r1"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestPullRequestReviewWithoutBody(t *testing.T) {
+	var payload PullRequestReviewPayload
+	loadTestPayload(t, "pull_request_review.json", &payload)
+	// The review's line comments are sent as their own events.
+	state := "commented"
+	payload.Review.State = &state
+	result, err := handlePullRequestReviewPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 0 {
+		t.Errorf("got %d emails", len(result.Emails))
+	}
+}
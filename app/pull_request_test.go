@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPullRequestEmail(t *testing.T) {
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/pulls/7/commits": "pull-commits.json",
+	})()
+	var payload PullRequestPayload
+	loadTestPayload(t, "pull_request.json", &payload)
+	result, err := handlePullRequestPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] #7: Add thing" || email.SenderUserName != "alice" {
+		t.Errorf("got %+v", email)
+	}
+	// Emails about the pull request, whichever is first, start its thread and
+	// later ones reply to it.
+	want := []string{"pull/o/r/7"}
+	if !reflect.DeepEqual(result.NewThreadKeys, want) || !reflect.DeepEqual(result.ReplyThreadKeys, want) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	for _, s := range []string{">opened<", "bob:feature", "o:master", "Second commit",
+		"https://github.com/o/r/commit/2222222222222222222222222222222222222222"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+
+	// The commits are only listed for actions that change them.
+	action := "ready_for_review"
+	payload.Action = &action
+	result, err = handlePullRequestPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.Emails[0].HTMLBody, "Second commit") {
+		t.Errorf("commits listed for %s", action)
+	}
+
+	// Or without a token.
+	hookConfig.GitHubToken = ""
+	loadTestPayload(t, "pull_request.json", &payload)
+	result, err = handlePullRequestPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.Emails[0].HTMLBody, "Second commit") {
+		t.Error("commits listed without a token")
+	}
+}
+
+func TestPullRequestActions(t *testing.T) {
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	defer serveGitHubAPI(nil)()
+	var payload PullRequestPayload
+	loadTestPayload(t, "pull_request.json", &payload)
+	merged := true
+	payload.PullRequest.Merged = &merged
+	tests := []struct {
+		action      string
+		description string
+	}{
+		{"closed", "merged"},
+		{"reopened", "reopened"},
+		{"ready_for_review", "marked as ready for review"},
+		{"labeled", ""},
+	}
+	for _, test := range tests {
+		action := test.action
+		payload.Action = &action
+		result, err := handlePullRequestPayload(payload, testContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.description == "" {
+			if len(result.Emails) != 0 {
+				t.Errorf("%s: got %d emails", test.action, len(result.Emails))
+			}
+			continue
+		}
+		// The email is still sent when the commits can't be fetched.
+		if len(result.Emails) != 1 || !strings.Contains(result.Emails[0].HTMLBody, ">"+test.description+"<") {
+			t.Errorf("%s: not described as %q", test.action, test.description)
+		}
+	}
+}
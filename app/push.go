@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type pushEventHandler struct{}
+
+func init() {
+	registerEventHandler("push", pushEventHandler{})
+}
+
+func (pushEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload PushPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handlePushPayload(payload, c)
+}
+
+const (
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+)
+
+// parseRef splits a full ref name into its type ("branch" or "tag") and short
+// name.
+func parseRef(ref string) (refType string, name string) {
+	if strings.HasPrefix(ref, branchRefPrefix) {
+		return "branch", ref[len(branchRefPrefix):]
+	}
+	if strings.HasPrefix(ref, tagRefPrefix) {
+		return "tag", ref[len(tagRefPrefix):]
+	}
+	return "ref", ref
+}
+
+// pushSenderName returns the pusher's display name. We don't have it in the
+// pusher, but usually it's one of the commiters, so get it from there (without
+// having to do any extra API requests)
+func pushSenderName(payload PushPayload) string {
+	senderUserName := *payload.Pusher.Name
+	commits := payload.Commits
+	if payload.HeadCommit != nil {
+		commits = append([]WebHookCommit{*payload.HeadCommit}, commits...)
+	}
+	for _, commit := range commits {
+		for _, author := range []*github.WebHookAuthor{commit.Author, commit.Committer} {
+			if author != nil && author.Username != nil && *author.Username == senderUserName && author.Name != nil {
+				return *author.Name
+			}
+		}
+	}
+	return senderUserName
+}
+
+// GitHub includes at most this many commits in push payloads.
+const pushPayloadCommitLimit = 20
+
+const defaultPushCommitLimit = 100
+
+func pushCommitLimit() int {
+	if hookConfig.PushCommitLimit > 0 {
+		return hookConfig.PushCommitLimit
+	}
+	return defaultPushCommitLimit
+}
+
+// pushCommits returns the commits of a push, and how many there are in total.
+// If GitHub truncated the payload's list, the full one is fetched via the
+// compare API (which itself returns at most 250 commits, but does report the
+// total).
+func pushCommits(payload PushPayload, c context.Context) ([]WebHookCommit, int) {
+	commits := payload.Commits
+	if len(commits) < pushPayloadCommitLimit ||
+		strings.Trim(*payload.Before, "0") == "" {
+		return commits, len(commits)
+	}
+	comparison, err := fetchComparison(payload.Repo, *payload.Before, *payload.After, c)
+	if err != nil {
+		log.Warningf(c, "Could not fetch the full list of pushed commits: %s", err)
+		return commits, len(commits)
+	}
+	if len(comparison.Commits) <= len(commits) {
+		return commits, len(commits)
+	}
+	// Payload commits have file lists, so prefer them to the compare API's.
+	payloadCommits := make(map[string]*WebHookCommit)
+	for i := range commits {
+		payloadCommits[*commits[i].ID] = &commits[i]
+	}
+	fullCommits := make([]WebHookCommit, 0, len(comparison.Commits))
+	for i := range comparison.Commits {
+		if commit, ok := payloadCommits[*comparison.Commits[i].SHA]; ok {
+			fullCommits = append(fullCommits, *commit)
+		} else {
+			fullCommits = append(fullCommits, comparison.Commits[i].WebHookCommit())
+		}
+	}
+	totalCount := len(fullCommits)
+	if comparison.TotalCommits != nil && *comparison.TotalCommits > totalCount {
+		totalCount = *comparison.TotalCommits
+	}
+	return fullCommits, totalCount
+}
+
+// Values for HookConfig.NonDistinctCommits.
+const (
+	NonDistinctCommitsShow     = "show"
+	NonDistinctCommitsDrop     = "drop"
+	NonDistinctCommitsCollapse = "collapse"
+)
+
+// DisplayNonDistinctCommit is a commit that had already been pushed (to
+// another branch), shown as a one-line summary.
+type DisplayNonDistinctCommit struct {
+	DisplayCommitSummary
+	// The subject of the email that the commit was originally sent in, if
+	// any, and a (RFC 2392 mid:) link to it.
+	ThreadSubject string
+	ThreadURL     template.URL
+}
+
+// messageURL returns a link to the email with the given Message-ID (which
+// opens it in mail clients that support mid: URLs).
+func messageURL(messageId string) template.URL {
+	messageId = strings.TrimSuffix(strings.TrimPrefix(messageId, "<"), ">")
+	return template.URL("mid:" + url.PathEscape(messageId))
+}
+
+// filterNonDistinctCommits removes the commits that had already been pushed
+// elsewhere (as configured by HookConfig.NonDistinctCommits), returning the
+// remaining ones and the summaries of those that should be collapsed.
+// Commits from the API (when the payload doesn't list all of a push's
+// commits) aren't known to be distinct or not, and are treated as distinct.
+func filterNonDistinctCommits(commits []WebHookCommit, c context.Context) ([]WebHookCommit, []DisplayNonDistinctCommit) {
+	policy := hookConfig.NonDistinctCommits
+	if policy != NonDistinctCommitsDrop && policy != NonDistinctCommitsCollapse {
+		return commits, nil
+	}
+	distinctCommits := make([]WebHookCommit, 0, len(commits))
+	var nonDistinctCommits []DisplayNonDistinctCommit
+	for i := range commits {
+		commit := &commits[i]
+		if commit.Distinct == nil || *commit.Distinct {
+			distinctCommits = append(distinctCommits, *commit)
+			continue
+		}
+		if policy == NonDistinctCommitsCollapse {
+			nonDistinctCommit := DisplayNonDistinctCommit{
+				DisplayCommitSummary: newDisplayCommitSummary(commit),
+			}
+			if thread := getEmailThread(*commit.ID, c); thread != nil {
+				nonDistinctCommit.ThreadSubject = thread.Subject
+				if len(thread.MessageID) > 0 {
+					nonDistinctCommit.ThreadURL = messageURL(thread.MessageID)
+				}
+			}
+			nonDistinctCommits = append(nonDistinctCommits, nonDistinctCommit)
+		}
+	}
+	return distinctCommits, nonDistinctCommits
+}
+
+func pushedDate(payload PushPayload, location *time.Location) time.Time {
+	if payload.Repo.PushedAt != nil {
+		return payload.Repo.PushedAt.In(location)
+	}
+	return time.Now().In(location)
+}
+
+func handlePushPayload(payload PushPayload, c context.Context) (*EventResult, error) {
+	refType, refName := parseRef(*payload.Ref)
+	deleted := payload.Deleted != nil && *payload.Deleted
+	forced := payload.Forced != nil && *payload.Forced
+	// Deletions, tags and new branches without new commits don't have
+	// anything to show besides the ref itself. Force pushes may not have new
+	// commits either (if the branch was reset), but we still want to show
+	// what was dropped.
+	if deleted || refType == "tag" || (len(payload.Commits) == 0 && !forced) {
+		return handleRefPushPayload(payload, refType, refName, c)
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+
+	commits, commitCount := pushCommits(payload, c)
+	distinctCommits, nonDistinctCommits := filterNonDistinctCommits(commits, c)
+	// Non-distinct commits still count as pushed, but are not shown in full.
+	nonDistinctCount := len(commits) - len(distinctCommits)
+	commits = distinctCommits
+	if len(commits) == 0 && len(nonDistinctCommits) == 0 && !forced {
+		log.Infof(c, "Ignoring push to %s, all of its commits were already pushed", refName)
+		return &EventResult{}, nil
+	}
+	if len(commits) > pushCommitLimit() {
+		commits = commits[:pushCommitLimit()]
+	}
+	displayCommits := newDisplayCommits(commits, payload.Sender, payload.Repo, location, c)
+	threadKeys := make([]string, 0, len(displayCommits))
+	for _, commit := range displayCommits {
+		threadKeys = append(threadKeys, commit.SHA)
+	}
+	// Line counts (and diffs) require an API request per commit, so they're
+	// only fetched when a GitHub token is configured.
+	var pushStat *DisplayDiffStat
+	if hasGitHubToken() {
+		details := fetchCommitDetails(displayCommits, payload.Repo, c)
+		addMissingFiles(displayCommits, details)
+		addDiffStats(displayCommits, details)
+		if hookConfig.InlineDiffs {
+			addInlineDiffs(displayCommits, details)
+		}
+		pushStat = newPushDiffStat(displayCommits, details)
+	}
+	displayCommits = collapseMergedPullRequests(commits, displayCommits, payload.Repo, c)
+	branchUrl := fmt.Sprintf("https://github.com/%s/tree/%s", *payload.Repo.FullName, refName)
+	pushedDate := pushedDate(payload, location)
+	compareUrl := fmt.Sprintf("https://github.com/%s/compare/%s...%s",
+		*payload.Repo.FullName, *payload.Before, *payload.After)
+	var droppedCommits []DisplayCommitSummary
+	if forced {
+		droppedCommits = fetchDroppedCommits(payload, c)
+	}
+	// Last link is a link so that the GitHub Gmail extension
+	// (https://github.com/muan/github-gmail) will open the diff view.
+	extensionUrl := compareUrl
+	if len(displayCommits) == 1 {
+		extensionUrl = displayCommits[0].URL
+	} else if len(displayCommits) > 1 && payload.Compare != nil {
+		extensionUrl = *payload.Compare
+	}
+	var data = map[string]interface{}{
+		"Payload":                  payload,
+		"Commits":                  displayCommits,
+		"CommitCount":              commitCount,
+		"MoreCommitCount":          commitCount - nonDistinctCount - len(commits),
+		"NonDistinctCommits":       nonDistinctCommits,
+		"Stats":                    pushStat,
+		"StatsCommitCount":         len(commits),
+		"RefType":                  refType,
+		"Created":                  payload.Created != nil && *payload.Created,
+		"BranchName":               refName,
+		"BranchURL":                branchUrl,
+		"PushedDisplayDate":        safeFormattedDate(pushedDate.Format(DisplayDateFormat)),
+		"PushedDisplayDateTooltip": pushedDate.Format(DisplayDateFullFormat),
+		"ExtensionURL":             extensionUrl,
+		"Forced":                   forced,
+		"CompareURL":               compareUrl,
+		"BeforeShortSHA":           (*payload.Before)[:7],
+		"AfterShortSHA":            (*payload.After)[:7],
+		"DroppedCommits":           droppedCommits,
+	}
+	htmlBody, textBody, err := renderEmailBodies("push", data)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectPrefix := fmt.Sprintf("[%s]", *payload.Repo.FullName)
+	if forced {
+		subjectPrefix += " [forced]"
+	}
+	var subject string
+	var replyThreadKeys []string
+	if len(displayCommits) > 0 {
+		subjectCommit := displayCommits[0]
+		subjectTitle := subjectCommit.Title
+		if subjectCommit.MergedPullRequest != nil {
+			subjectTitle = fmt.Sprintf("Merged PR #%d: %s",
+				subjectCommit.MergedPullRequest.Number, subjectCommit.MergedPullRequest.Title)
+		}
+		subject = fmt.Sprintf("%s %s: %s", subjectPrefix, subjectCommit.ShortSHA, subjectTitle)
+	} else if len(nonDistinctCommits) > 0 {
+		// Nothing new, so reply to the email for the original push.
+		subjectCommit := nonDistinctCommits[0]
+		subject = fmt.Sprintf("%s %s: %s (now on %s)", subjectPrefix, subjectCommit.ShortSHA, subjectCommit.Title, refName)
+		for _, commit := range nonDistinctCommits {
+			replyThreadKeys = append(replyThreadKeys, commit.SHA)
+		}
+	} else {
+		subject = fmt.Sprintf("%s %s reset to %s", subjectPrefix, refName, (*payload.After)[:7])
+	}
+
+	message := &Email{
+		SenderName:     pushSenderName(payload),
+		SenderUserName: emailUserName(*payload.Pusher.Name),
+		Subject:        subject,
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   threadKeys,
+		ReplyThreadKeys: replyThreadKeys,
+	}, nil
+}
+
+// fetchDroppedCommits returns the commits that a force push removed from the
+// branch (i.e. that are reachable from Before but not After). This requires an
+// API request, so it's only done when a GitHub token is configured.
+func fetchDroppedCommits(payload PushPayload, c context.Context) []DisplayCommitSummary {
+	if !hasGitHubToken() {
+		return nil
+	}
+	comparison, err := fetchComparison(payload.Repo, *payload.After, *payload.Before, c)
+	if err != nil {
+		log.Warningf(c, "Could not fetch commits dropped by force push: %s", err)
+		return nil
+	}
+	droppedCommits := make([]DisplayCommitSummary, 0, len(comparison.Commits))
+	for i := range comparison.Commits {
+		commit := comparison.Commits[i].WebHookCommit()
+		droppedCommits = append(droppedCommits, newDisplayCommitSummary(&commit))
+	}
+	return droppedCommits
+}
+
+// handleRefPushPayload handles pushes that create or delete a branch or tag
+// (or otherwise don't include any commits).
+func handleRefPushPayload(payload PushPayload, refType string, refName string, c context.Context) (*EventResult, error) {
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+
+	action, actionState := "updated", "edited"
+	if payload.Deleted != nil && *payload.Deleted {
+		action, actionState = "deleted", "closed"
+	} else if payload.Created != nil && *payload.Created {
+		action, actionState = "created", "open"
+	}
+
+	var headCommit *DisplayCommit
+	if payload.HeadCommit != nil && action != "deleted" {
+		displayCommit := newDisplayCommit(payload.HeadCommit, payload.Sender, payload.Repo, location, c)
+		headCommit = &displayCommit
+	}
+	refUrl := fmt.Sprintf("https://github.com/%s/tree/%s", *payload.Repo.FullName, refName)
+	pushedDate := pushedDate(payload, location)
+
+	var data = map[string]interface{}{
+		"Payload":                  payload,
+		"Sender":                   payload.Sender,
+		"Action":                   action,
+		"ActionState":              "pull.state." + actionState,
+		"RefType":                  refType,
+		"RefName":                  refName,
+		"RefURL":                   refUrl,
+		"HeadCommit":               headCommit,
+		"PushedDisplayDate":        safeFormattedDate(pushedDate.Format(DisplayDateFormat)),
+		"PushedDisplayDateTooltip": pushedDate.Format(DisplayDateFullFormat),
+	}
+	htmlBody, textBody, err := renderEmailBodies("push-ref", data)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := fmt.Sprintf("[%s] %s %s %s", *payload.Repo.FullName, refType, refName, action)
+
+	message := &Email{
+		SenderName:     pushSenderName(payload),
+		SenderUserName: emailUserName(*payload.Pusher.Name),
+		Subject:        subject,
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails: []*Email{message},
+	}, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		refType string
+		name    string
+	}{
+		{"refs/heads/feature/x", "branch", "feature/x"},
+		// Tags don't have the same prefix length as branches.
+		{"refs/tags/v1.0", "tag", "v1.0"},
+		{"refs/notes/commits", "ref", "refs/notes/commits"},
+	}
+	for _, test := range tests {
+		refType, name := parseRef(test.ref)
+		if refType != test.refType || name != test.name {
+			t.Errorf("%s: got %s %s", test.ref, refType, name)
+		}
+	}
+}
+
+func TestPushRefs(t *testing.T) {
+	tests := []struct {
+		fileName string
+		subject  string
+		sender   string
+		body     string
+	}{
+		{"push-tag.json", "[o/r] tag v1.0 created", "Bob B", "Second commit"},
+		{"push-delete.json", "[o/r] branch feature/x deleted", "bob", "2222222222222222222222222222222222222222"},
+	}
+	for _, test := range tests {
+		var payload PushPayload
+		loadTestPayload(t, test.fileName, &payload)
+		result, err := handlePushPayload(payload, testContext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Emails) != 1 {
+			t.Fatalf("%s: got %d emails", test.fileName, len(result.Emails))
+		}
+		email := result.Emails[0]
+		if email.Subject != test.subject || email.SenderName != test.sender {
+			t.Errorf("%s: got %q from %q", test.fileName, email.Subject, email.SenderName)
+		}
+		if !strings.Contains(email.HTMLBody, test.body) {
+			t.Errorf("%s: body does not contain %q", test.fileName, test.body)
+		}
+		// There's no commit for replies to be threaded under.
+		if len(result.NewThreadKeys) != 0 {
+			t.Errorf("%s: got new thread keys %v", test.fileName, result.NewThreadKeys)
+		}
+	}
+}
+
+func TestPushNewBranch(t *testing.T) {
+	var payload PushPayload
+	loadTestPayload(t, "push.json", &payload)
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.Emails[0].HTMLBody, "new branch") {
+		t.Error("existing branch described as new")
+	}
+
+	created := true
+	payload.Created = &created
+	result, err = handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.NewThreadKeys) != 2 || !strings.Contains(result.Emails[0].HTMLBody, "new branch") {
+		t.Errorf("got thread keys %v and body:\n%s", result.NewThreadKeys, result.Emails[0].HTMLBody)
+	}
+}
+
+func TestPushForced(t *testing.T) {
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/compare/2222222222222222222222222222222222222222...1111111111111111111111111111111111111111": "compare.json",
+	})()
+	var payload PushPayload
+	loadTestPayload(t, "push-forced.json", &payload)
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] [forced] aaaaaaa: First commit" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	// The commits that are no longer on the branch are listed, and the
+	// comparison links to the new and old heads.
+	for _, s := range []string{"Dropped one", "https://github.com/o/r/compare/1111111111111111111111111111111111111111...2222222222222222222222222222222222222222"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+
+	// A reset has no new commits, but is still described as a push.
+	loadTestPayload(t, "push-reset.json", &payload)
+	result, err = handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email := result.Emails[0]; email.Subject != "[o/r] [forced] master reset to 2222222" ||
+		!strings.Contains(email.HTMLBody, "Dropped one") {
+		t.Errorf("got %q with body:\n%s", email.Subject, email.HTMLBody)
+	}
+}
+
+func TestPushForcedWithoutToken(t *testing.T) {
+	// Finding the dropped commits needs an API request.
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/compare/2222222222222222222222222222222222222222...1111111111111111111111111111111111111111": "compare.json",
+	})()
+	var payload PushPayload
+	loadTestPayload(t, "push-forced.json", &payload)
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.Emails[0].HTMLBody, "Dropped one") {
+		t.Error("dropped commits fetched without a token")
+	}
+}
+
+func TestPushCommitLimit(t *testing.T) {
+	defer withHookConfig(HookConfig{PushCommitLimit: 25})()
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/compare/eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee...000000000000000000000000000000000000001e": "compare-large.json",
+	})()
+	// The payload only has the last 20 commits, the rest come from the
+	// comparison.
+	var payload PushPayload
+	loadTestPayload(t, "push-large.json", &payload)
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.NewThreadKeys) != 25 {
+		t.Errorf("got %d new thread keys, want 25", len(result.NewThreadKeys))
+	}
+	for _, s := range []string{"30 commits", "5 more commits", "Commit 0<"} {
+		if !strings.Contains(result.Emails[0].HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+
+	// Payloads that weren't truncated are used as is.
+	loadTestPayload(t, "push.json", &payload)
+	result, err = handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := result.Emails[0].HTMLBody; !strings.Contains(body, "2 commits") || strings.Contains(body, "not shown") {
+		t.Errorf("got body:\n%s", body)
+	}
+}
+
+func TestPushStatsLabel(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/commits/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": "commit.json",
+		"/repos/o/r/commits/2222222222222222222222222222222222222222": "commit.json",
+	})()
+	var payload PushPayload
+	loadTestPayload(t, "push.json", &payload)
+	for _, limit := range []int{0, 1} {
+		restore := withHookConfig(HookConfig{GitHubToken: "token", PushCommitLimit: limit})
+		result, err := handlePushPayload(payload, testContext)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		email := result.Emails[0]
+		// The totals only cover the commits that are shown, which is only
+		// worth pointing out when some aren't.
+		truncated := limit > 0
+		for _, body := range []string{email.HTMLBody, email.TextBody} {
+			if !strings.Contains(body, "files changed") {
+				t.Errorf("limit %d: body has no totals:\n%s", limit, body)
+			}
+			if strings.Contains(body, "In the 1 commits shown,") != truncated {
+				t.Errorf("limit %d: got body:\n%s", limit, body)
+			}
+		}
+	}
+}
+
+func TestPushNonDistinctCommits(t *testing.T) {
+	var payload PushPayload
+	loadTestPayload(t, "push-fast-forward.json", &payload)
+
+	defer withHookConfig(HookConfig{NonDistinctCommits: NonDistinctCommitsDrop})()
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 0 {
+		t.Errorf("got %d emails for a push of only dropped commits", len(result.Emails))
+	}
+
+	hookConfig.NonDistinctCommits = NonDistinctCommitsCollapse
+	createThread("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "[o/r] aaaaaaa: First commit", "<first@example.com>", testContext)
+	result, err = handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] aaaaaaa: First commit (now on master)" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	// Collapsed commits don't start threads, the push is a reply to the
+	// original push's instead.
+	wantReplyKeys := []string{
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"2222222222222222222222222222222222222222",
+	}
+	if len(result.NewThreadKeys) != 0 || !reflect.DeepEqual(result.ReplyThreadKeys, wantReplyKeys) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	// They link to the email they were originally sent in.
+	for _, s := range []string{"2 commits that were", `href="mid:first@example.com"`, "“[o/r] aaaaaaa: First commit”"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestPushTextBody(t *testing.T) {
+	var payload PushPayload
+	loadTestPayload(t, "push.json", &payload)
+	result, err := handlePushPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := result.Emails[0].TextBody
+	for _, s := range []string{
+		"* First commit\n  https://github.com/o/r/commit/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"* Second commit",
+		"2 commits pushed to master",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("text body does not contain %q:\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, "<") {
+		t.Errorf("text body has markup:\n%s", body)
+	}
+}
+
+func TestApiCommitDistinctness(t *testing.T) {
+	// Commits fetched from the API aren't known to have been pushed before,
+	// so they're always shown.
+	var comparison ApiComparison
+	loadTestPayload(t, "api/compare.json", &comparison)
+	commit := comparison.Commits[0].WebHookCommit()
+	if commit.Distinct != nil {
+		t.Errorf("got distinct %v", *commit.Distinct)
+	}
+	defer withHookConfig(HookConfig{NonDistinctCommits: NonDistinctCommitsDrop})()
+	if distinctCommits, _ := filterNonDistinctCommits([]WebHookCommit{commit}, testContext); len(distinctCommits) != 1 {
+		t.Error("API commit was dropped")
+	}
+}
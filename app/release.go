@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type releaseEventHandler struct{}
+
+func init() {
+	registerEventHandler("release", releaseEventHandler{})
+}
+
+func (releaseEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload ReleasePayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleReleasePayload(payload, c)
+}
+
+type DisplayReleaseAsset struct {
+	Name string
+	URL  string
+	Size string
+}
+
+func releaseDate(release *WebHookRelease) time.Time {
+	if release.PublishedAt != nil {
+		return *release.PublishedAt
+	}
+	if release.CreatedAt != nil {
+		return *release.CreatedAt
+	}
+	return time.Time{}
+}
+
+// findPreviousRelease returns the most recent published release before the
+// given one, or nil if there isn't one.
+func findPreviousRelease(release *WebHookRelease, releases []WebHookRelease) *WebHookRelease {
+	var previous *WebHookRelease
+	for i := range releases {
+		candidate := &releases[i]
+		if *candidate.ID == *release.ID || (candidate.Draft != nil && *candidate.Draft) {
+			continue
+		}
+		if !releaseDate(candidate).Before(releaseDate(release)) {
+			continue
+		}
+		if previous == nil || releaseDate(candidate).After(releaseDate(previous)) {
+			previous = candidate
+		}
+	}
+	return previous
+}
+
+func handleReleasePayload(payload ReleasePayload, c context.Context) (*EventResult, error) {
+	release := payload.Release
+	action := *payload.Action
+	actionDescription, actionState := "", ""
+	switch action {
+	case "published":
+		// GitHub also sends a prereleased event for pre-releases, which is
+		// ignored so that they're only sent once.
+		if release.Prerelease != nil && *release.Prerelease {
+			actionDescription, actionState = "published pre-release", "draft"
+		} else {
+			actionDescription, actionState = "published", "open"
+		}
+	case "edited":
+		actionDescription, actionState = "edited", "edited"
+	default:
+		log.Infof(c, "Ignoring release %s action", action)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	publishedDate := releaseDate(release).In(location)
+
+	body := ""
+	if release.Body != nil && len(*release.Body) > 0 {
+		body = renderMessageMarkdown(*release.Body, payload.Repo, c)
+	}
+	assets := make([]DisplayReleaseAsset, 0, len(release.Assets))
+	for _, asset := range release.Assets {
+		assets = append(assets, DisplayReleaseAsset{
+			Name: *asset.Name,
+			URL:  *asset.BrowserDownloadURL,
+			Size: formatFileSize(*asset.Size),
+		})
+	}
+
+	var previousRelease *WebHookRelease
+	var compareUrl string
+	var displayCommits []DisplayCommit
+	moreCommitCount := 0
+	releases, err := fetchReleases(payload.Repo, c)
+	if err != nil {
+		log.Warningf(c, "Could not fetch releases: %s", err)
+	} else {
+		previousRelease = findPreviousRelease(release, releases)
+	}
+	if previousRelease != nil {
+		compareUrl = fmt.Sprintf("https://github.com/%s/compare/%s...%s",
+			*payload.Repo.FullName, *previousRelease.TagName, *release.TagName)
+		displayCommits, moreCommitCount, err = newDisplayCommitsForComparison(
+			payload.Repo, *previousRelease.TagName, *release.TagName, payload.Sender, location, c)
+		if err != nil {
+			log.Warningf(c, "Could not fetch commits since %s: %s", *previousRelease.TagName, err)
+		}
+	}
+
+	var data = map[string]interface{}{
+		"Payload":              payload,
+		"Release":              release,
+		"Sender":               payload.Sender,
+		"Repo":                 payload.Repo,
+		"ActionDescription":    actionDescription,
+		"ActionState":          "pull.state." + actionState,
+		"Body":                 body,
+		"Assets":               assets,
+		"PreviousRelease":      previousRelease,
+		"CompareURL":           compareUrl,
+		"Commits":              displayCommits,
+		"MoreCommitCount":      moreCommitCount,
+		"PublishedDisplayDate": safeFormattedDate(publishedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("release", data)
+	if err != nil {
+		return nil, err
+	}
+
+	senderUserName := *payload.Sender.Login
+	name := *release.TagName
+	if release.Name != nil && len(*release.Name) > 0 && *release.Name != name {
+		name = fmt.Sprintf("%s: %s", name, *release.Name)
+	}
+	threadKey := fmt.Sprintf("release/%s/%d", *payload.Repo.FullName, *release.ID)
+
+	message := &Email{
+		SenderName:     senderUserName,
+		SenderUserName: emailUserName(senderUserName),
+		Subject:        fmt.Sprintf("[%s] Release %s", *payload.Repo.FullName, name),
+		HTMLBody:       htmlBody,
+		TextBody:       textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		NewThreadKeys:   []string{threadKey},
+		ReplyThreadKeys: []string{threadKey},
+	}, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReleaseEmail(t *testing.T) {
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/releases":            "releases.json",
+		"/repos/o/r/compare/v1.0...v1.1": "compare.json",
+	})()
+	var payload ReleasePayload
+	loadTestPayload(t, "release.json", &payload)
+	result, err := handleReleasePayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] Release v1.1: Second" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	want := []string{"release/o/r/2"}
+	if !reflect.DeepEqual(result.NewThreadKeys, want) || !reflect.DeepEqual(result.ReplyThreadKeys, want) {
+		t.Errorf("got thread keys %v and %v", result.NewThreadKeys, result.ReplyThreadKeys)
+	}
+	// Assets are listed with their sizes, and the commits since the previous
+	// release (v1.0, not the older v0.9) are included.
+	for _, s := range []string{"bin.tgz", "1.5 MB", "v1.0</a>", "Dropped one"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestReleaseActions(t *testing.T) {
+	defer serveGitHubAPI(nil)()
+	tests := []struct {
+		action      string
+		prerelease  bool
+		description string
+	}{
+		{"published", false, "published"},
+		{"published", true, "published pre-release"},
+		{"edited", false, "edited"},
+		// GitHub sends this in addition to published, so it's ignored.
+		{"prereleased", true, ""},
+		{"created", false, ""},
+	}
+	for _, test := range tests {
+		var payload ReleasePayload
+		loadTestPayload(t, "release.json", &payload)
+		action, prerelease := test.action, test.prerelease
+		payload.Action = &action
+		payload.Release.Prerelease = &prerelease
+		result, err := handleReleasePayload(payload, testContext)
+		if err != nil {
+			t.Fatalf("%s: %s", test.action, err)
+		}
+		if len(test.description) == 0 {
+			if len(result.Emails) != 0 {
+				t.Errorf("%s: got %d emails", test.action, len(result.Emails))
+			}
+			continue
+		}
+		// Without the previous release, the email is still sent.
+		if len(result.Emails) != 1 || !strings.Contains(result.Emails[0].HTMLBody, ">"+test.description+"<") {
+			t.Errorf("%s: not described as %q", test.action, test.description)
+		}
+	}
+}
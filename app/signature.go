@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const signaturePrefix = "sha256="
+
+var (
+	errMissingSignature = errors.New("missing X-Hub-Signature-256 header")
+	errInvalidSignature = errors.New("signature does not match payload")
+)
+
+// verifyHookRequest checks that a webhook delivery was signed with one of the
+// configured secrets. The payload is only parsed once a secret has matched,
+// since it can't be trusted before then. Secrets in WebhookSecrets are only
+// accepted for deliveries about their repository, DefaultWebhookSecret is
+// accepted for any delivery (including those from organization hooks, which
+// don't have a repository).
+func verifyHookRequest(r *http.Request, body []byte) error {
+	repoFullNames, matchedDefault, err := matchHookSignature(
+		body, r.Header.Get("X-Hub-Signature-256"), hookConfig.WebhookSecrets, hookConfig.DefaultWebhookSecret)
+	if err != nil || matchedDefault {
+		return err
+	}
+	var payload struct {
+		Repo *WebHookRepository `json:"repository,omitempty"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("could not parse payload: %s", err)
+	}
+	if payload.Repo == nil || payload.Repo.FullName == nil {
+		return errors.New("payload has no repository, and was not signed with the default secret")
+	}
+	for _, repoFullName := range repoFullNames {
+		if repoFullName == *payload.Repo.FullName {
+			return nil
+		}
+	}
+	return fmt.Errorf("payload for %s was signed with the secret of %s",
+		*payload.Repo.FullName, strings.Join(repoFullNames, ", "))
+}
+
+// matchHookSignature returns the repositories whose secret the body was signed
+// with, and whether the default secret matched (several repositories may share
+// a secret). errInvalidSignature is returned if none of them did.
+func matchHookSignature(body []byte, signature string, secrets map[string]string, defaultSecret string) (repoFullNames []string, matchedDefault bool, err error) {
+	if len(signature) == 0 {
+		return nil, false, errMissingSignature
+	}
+	if len(defaultSecret) > 0 && verifyHookSignature(body, signature, defaultSecret) == nil {
+		return nil, true, nil
+	}
+	for repoFullName, secret := range secrets {
+		if len(secret) > 0 && verifyHookSignature(body, signature, secret) == nil {
+			repoFullNames = append(repoFullNames, repoFullName)
+		}
+	}
+	if len(repoFullNames) == 0 {
+		return nil, false, errInvalidSignature
+	}
+	sort.Strings(repoFullNames)
+	return repoFullNames, false, nil
+}
+
+// verifyHookSignature checks an X-Hub-Signature-256 header value (of the form
+// "sha256=<hex digest>") against the HMAC-SHA256 of the body.
+func verifyHookSignature(body []byte, signature string, secret string) error {
+	if len(signature) == 0 {
+		return errMissingSignature
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return errInvalidSignature
+	}
+	signatureBytes, err := hex.DecodeString(signature[len(signaturePrefix):])
+	if err != nil {
+		return errInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(signatureBytes, mac.Sum(nil)) {
+		return errInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyHookSignature(t *testing.T) {
+	// The example from GitHub's documentation for validating deliveries.
+	const body = "Hello, World!"
+	const secret = "It's a Secret to Everybody"
+	const signature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		secret    string
+		want      error
+	}{
+		{"valid", body, signature, secret, nil},
+		{"uppercase hex", body, signature[:7] + strings.ToUpper(signature[7:]), secret, nil},
+		{"uppercase prefix", body, strings.ToUpper(signature[:7]) + signature[7:], secret, errInvalidSignature},
+		{"wrong secret", body, signature, "It's a Secret to Nobody", errInvalidSignature},
+		{"modified body", body + "\n", signature, secret, errInvalidSignature},
+		{"missing", body, "", secret, errMissingSignature},
+		{"sha1 prefix", body, "sha1=" + signature[len("sha256="):], secret, errInvalidSignature},
+		{"no prefix", body, signature[len("sha256="):], secret, errInvalidSignature},
+		{"not hex", body, "sha256=not-a-digest", secret, errInvalidSignature},
+		{"truncated", body, signature[:len(signature)-2], secret, errInvalidSignature},
+	}
+	for _, test := range tests {
+		if err := verifyHookSignature([]byte(test.body), test.signature, test.secret); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestVerifyHookRequest(t *testing.T) {
+	savedConfig := hookConfig
+	defer func() { hookConfig = savedConfig }()
+	hookConfig.WebhookSecrets = map[string]string{
+		"o/r":      "repo-secret",
+		"o/other":  "other-secret",
+		"o/shared": "repo-secret",
+		"o/empty":  "",
+	}
+	hookConfig.DefaultWebhookSecret = "default-secret"
+
+	const repoBody = `{"repository":{"full_name":"o/r"}}`
+	const orgBody = `{"organization":{"login":"o"}}`
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		wantErr   bool
+	}{
+		{"repository secret", repoBody,
+			"sha256=d2e7a467833dab0803dd2c1bbbff183fefed9922163d27f82fb6f074c54aa8ac", false},
+		{"other repository's secret", repoBody,
+			"sha256=859b8fcc231659224855959ddc9e12a465072db715d5f1db3deb991a42af5438", true},
+		{"default secret", repoBody,
+			"sha256=5d3b6cd0b342383c87678ca9b68f8b4e86e2d42c8eaedcb3aba54ceaa90c2058", false},
+		{"organization hook with default secret", orgBody,
+			"sha256=825429bd7ddb6ab77b63029aa6064137370243e19a1695712ee680d2863f95bb", false},
+		{"organization hook with repository secret", orgBody,
+			"sha256=12f744cbdbe946bf49cd2f028cda10483b0dd8f3f6263622fadd86e5cd4bec7a", true},
+		{"unknown secret", repoBody,
+			"sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17", true},
+		{"missing", repoBody, "", true},
+		{"malformed", repoBody, "sha256=d2e7a467833dab08", true},
+		{"unsigned garbage", "not json", "", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/hook", strings.NewReader(test.body))
+		if len(test.signature) > 0 {
+			r.Header.Set("X-Hub-Signature-256", test.signature)
+		}
+		err := verifyHookRequest(r, []byte(test.body))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got %v, want error: %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestMatchHookSignature(t *testing.T) {
+	body := []byte(`{"repository":{"full_name":"o/r"}}`)
+	signature := "sha256=d2e7a467833dab0803dd2c1bbbff183fefed9922163d27f82fb6f074c54aa8ac"
+	secrets := map[string]string{
+		"o/r":      "repo-secret",
+		"o/other":  "other-secret",
+		"o/shared": "repo-secret",
+	}
+	repoFullNames, matchedDefault, err := matchHookSignature(body, signature, secrets, "")
+	if err != nil || matchedDefault {
+		t.Fatalf("got %v, %v", matchedDefault, err)
+	}
+	if strings.Join(repoFullNames, ",") != "o/r,o/shared" {
+		t.Errorf("got %v, want both repositories that share the secret", repoFullNames)
+	}
+
+	if _, _, err := matchHookSignature(body, signature, nil, ""); err != errInvalidSignature {
+		t.Errorf("no secrets: got %v", err)
+	}
+	if _, _, err := matchHookSignature(body, "", secrets, ""); err != errMissingSignature {
+		t.Errorf("no signature: got %v", err)
+	}
+}
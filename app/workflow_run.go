@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/log"
+)
+
+type workflowRunEventHandler struct{}
+
+func init() {
+	registerEventHandler("workflow_run", workflowRunEventHandler{})
+}
+
+func (workflowRunEventHandler) Handle(payloadReader io.Reader, c context.Context) (*EventResult, error) {
+	var payload WorkflowRunPayload
+	if err := json.NewDecoder(payloadReader).Decode(&payload); err != nil {
+		return nil, err
+	}
+	return handleWorkflowRunPayload(payload, c)
+}
+
+type DisplayWorkflowJob struct {
+	Name       string
+	Conclusion string
+	// One of the pull.state.* styles.
+	ConclusionStyle string
+	URL             string
+	// Names of the steps that failed (only for failed jobs).
+	FailedSteps []string
+}
+
+// matchesAllowlist returns whether the name matches any of the patterns (see
+// path.Match, * doesn't match "/"), or true if there are none.
+func matchesAllowlist(name string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	for _, pattern := range allowlist {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func newDisplayWorkflowJob(job *ApiWorkflowJob) DisplayWorkflowJob {
+	displayJob := DisplayWorkflowJob{
+		Name: *job.Name,
+	}
+	if job.Conclusion != nil {
+		displayJob.Conclusion = *job.Conclusion
+	} else if job.Status != nil {
+		displayJob.Conclusion = *job.Status
+	}
+	displayJob.ConclusionStyle = checkConclusionStyle(displayJob.Conclusion)
+	if job.HTML_URL != nil {
+		displayJob.URL = *job.HTML_URL
+	}
+	if isFailedCheck(displayJob.Conclusion) {
+		for _, step := range job.Steps {
+			if step.Conclusion != nil && isFailedCheck(*step.Conclusion) {
+				displayJob.FailedSteps = append(displayJob.FailedSteps, *step.Name)
+			}
+		}
+	}
+	return displayJob
+}
+
+func handleWorkflowRunPayload(payload WorkflowRunPayload, c context.Context) (*EventResult, error) {
+	run := payload.WorkflowRun
+	if *payload.Action != "completed" || run.Conclusion == nil || !isFailedCheck(*run.Conclusion) {
+		return &EventResult{}, nil
+	}
+	workflowName := *run.Name
+	if payload.Workflow != nil && payload.Workflow.Name != nil {
+		workflowName = *payload.Workflow.Name
+	}
+	branch := ""
+	if run.HeadBranch != nil {
+		branch = *run.HeadBranch
+	}
+	if !matchesAllowlist(workflowName, hookConfig.WorkflowAllowlist) ||
+		!matchesAllowlist(branch, hookConfig.WorkflowBranchAllowlist) {
+		log.Infof(c, "Ignoring %s run on %s, not in allowlist", workflowName, branch)
+		return &EventResult{}, nil
+	}
+
+	// TODO: allow location to be customized
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	updatedDate := time.Now()
+	if run.UpdatedAt != nil {
+		updatedDate = *run.UpdatedAt
+	}
+	updatedDate = updatedDate.In(location)
+
+	sha := *run.HeadSHA
+	commitTitle := ""
+	if run.HeadCommit != nil && run.HeadCommit.Message != nil {
+		commitTitle, _ = getTitleAndMessageFromCommitMessage(*run.HeadCommit.Message)
+	}
+
+	// Listing jobs requires an API request, so it's only done when a GitHub
+	// token is configured.
+	var displayJobs []DisplayWorkflowJob
+	if hasGitHubToken() {
+		jobs, err := fetchWorkflowRunJobs(payload.Repo, *run.ID, c)
+		if err != nil {
+			log.Warningf(c, "Could not fetch jobs for workflow run %d: %s", *run.ID, err)
+		}
+		for i := range jobs {
+			displayJobs = append(displayJobs, newDisplayWorkflowJob(&jobs[i]))
+		}
+	}
+
+	var data = map[string]interface{}{
+		"Payload":            payload,
+		"Run":                run,
+		"WorkflowName":       workflowName,
+		"Conclusion":         *run.Conclusion,
+		"ConclusionStyle":    checkConclusionStyle(*run.Conclusion),
+		"Branch":             branch,
+		"BranchURL":          fmt.Sprintf("%s/tree/%s", *payload.Repo.HTMLURL, branch),
+		"Repo":               payload.Repo,
+		"ShortSHA":           sha[:7],
+		"CommitURL":          *payload.Repo.HTMLURL + "/commit/" + sha,
+		"CommitTitle":        commitTitle,
+		"Jobs":               displayJobs,
+		"UpdatedDisplayDate": safeFormattedDate(updatedDate.Format(DisplayDateFormat)),
+	}
+	htmlBody, textBody, err := renderEmailBodies("workflow-run", data)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Email{
+		SenderName:     workflowName,
+		SenderUserName: emailUserName(*payload.Sender.Login),
+		// Replaced with the commit's thread subject by threadEmails if the
+		// commit was mailed.
+		Subject: fmt.Sprintf("[%s] %s %s on %s (%s)",
+			*payload.Repo.FullName, workflowName, *run.Conclusion, branch, sha[:7]),
+		HTMLBody: htmlBody,
+		TextBody: textBody,
+	}
+	return &EventResult{
+		Emails:          []*Email{message},
+		ReplyThreadKeys: []string{sha},
+	}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowRunEmail(t *testing.T) {
+	defer withHookConfig(HookConfig{GitHubToken: "token"})()
+	defer serveGitHubAPI(map[string]string{
+		"/repos/o/r/actions/runs/77/jobs": "workflow-jobs.json",
+	})()
+	var payload WorkflowRunPayload
+	loadTestPayload(t, "workflow_run.json", &payload)
+	result, err := handleWorkflowRunPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 1 {
+		t.Fatalf("got %d emails", len(result.Emails))
+	}
+	email := result.Emails[0]
+	if email.Subject != "[o/r] CI failure on main (7777777)" || email.SenderName != "CI" {
+		t.Errorf("got %q from %q", email.Subject, email.SenderName)
+	}
+	// Failures are replies to the commit's email (if there is one).
+	if !reflect.DeepEqual(result.ReplyThreadKeys, []string{"7777777777777777777777777777777777777777"}) {
+		t.Errorf("got reply thread keys %v", result.ReplyThreadKeys)
+	}
+	// The failed jobs are listed with the steps that failed.
+	for _, s := range []string{"Fix the thing", "https://github.com/o/r/actions/runs/77", "test (linux)", "Run tests"} {
+		if !strings.Contains(email.HTMLBody, s) {
+			t.Errorf("body does not contain %q", s)
+		}
+	}
+}
+
+func TestWorkflowRunSuccess(t *testing.T) {
+	var payload WorkflowRunPayload
+	loadTestPayload(t, "workflow_run.json", &payload)
+	conclusion := "success"
+	payload.WorkflowRun.Conclusion = &conclusion
+	result, err := handleWorkflowRunPayload(payload, testContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Emails) != 0 {
+		t.Errorf("got %d emails", len(result.Emails))
+	}
+}
+
+func TestWorkflowRunAllowlist(t *testing.T) {
+	tests := []struct {
+		workflowAllowlist []string
+		branchAllowlist   []string
+		want              int
+	}{
+		{nil, nil, 1},
+		{[]string{"CI"}, []string{"main"}, 1},
+		{[]string{"C*"}, nil, 1},
+		{[]string{"Deploy"}, nil, 0},
+		{nil, []string{"release/*"}, 0},
+	}
+	for _, test := range tests {
+		restore := withHookConfig(HookConfig{
+			WorkflowAllowlist:       test.workflowAllowlist,
+			WorkflowBranchAllowlist: test.branchAllowlist,
+		})
+		var payload WorkflowRunPayload
+		loadTestPayload(t, "workflow_run.json", &payload)
+		result, err := handleWorkflowRunPayload(payload, testContext)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Emails) != test.want {
+			t.Errorf("%v, %v: got %d emails, want %d", test.workflowAllowlist, test.branchAllowlist, len(result.Emails), test.want)
+		}
+	}
+}
+
+func TestMatchesAllowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		want      bool
+	}{
+		{"main", nil, true},
+		{"main", []string{"main"}, true},
+		{"release/1.0", []string{"release/*"}, true},
+		// * doesn't match across slashes.
+		{"release/1.0/hotfix", []string{"release/*"}, false},
+		{"feature", []string{"main", "release/*"}, false},
+	}
+	for _, test := range tests {
+		if got := matchesAllowlist(test.name, test.allowlist); got != test.want {
+			t.Errorf("%q in %v: got %v, want %v", test.name, test.allowlist, got, test.want)
+		}
+	}
+}
@@ -6,4 +6,4 @@ DEST="$GOPATH/src/better-github-mail"
 rm -rf $DEST
 cp -r app $DEST
 cd $DEST
-gcloud app deploy --project better-github-mail app.yaml
+gcloud app deploy --project better-github-mail app.yaml cron.yaml queue.yaml index.yaml